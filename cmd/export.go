@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/deletescape/goop/pkg/goop"
+	"github.com/phuslu/log"
+	"github.com/spf13/cobra"
+)
+
+var exportPaths []string
+var archive string
+var exportCmd = &cobra.Command{
+	Use:   "export DIR OUT",
+	Short: "writes the files of every commit of a dumped repository into OUT",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := goop.Export(args[0], args[1], exportPaths, archive, force); err != nil {
+			log.Error().Err(err).Msg("exiting")
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringSliceVar(&exportPaths, "paths", nil, "only exports commits changing these paths")
+	exportCmd.Flags().StringVar(&archive, "archive", "", "writes each commit into an archive instead of a directory, either zip or tar.gz")
+	rootCmd.AddCommand(exportCmd)
+}
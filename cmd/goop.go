@@ -11,6 +11,13 @@ import (
 var force bool
 var keep bool
 var list bool
+var branches string
+var tags string
+var remotes string
+var files string
+var tagMisses int
+var placeholders bool
+var indexCommit bool
 var rootCmd = &cobra.Command{
 	Use:   "goop",
 	Short: "goop is a very fast tool to grab sources from exposed .git folders",
@@ -20,6 +27,13 @@ var rootCmd = &cobra.Command{
 		if len(args) >= 2 {
 			dir = args[1]
 		}
+		goop.TagMissLimit = tagMisses
+		goop.CheckoutPlaceholders = placeholders
+		goop.IndexCommit = indexCommit
+		if err := goop.LoadWordlists(branches, tags, remotes, files); err != nil {
+			log.Error().Err(err).Msg("exiting")
+			os.Exit(1)
+		}
 		if list {
 			if err := goop.CloneList(args[0], dir, force, keep); err != nil {
 				log.Error().Err(err).Msg("exiting")
@@ -38,6 +52,13 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&force, "force", "f", false, "overrides DIR if it already exists")
 	rootCmd.PersistentFlags().BoolVarP(&keep, "keep", "k", false, "keeps already downloaded files in DIR, useful if you keep being ratelimited by server")
 	rootCmd.PersistentFlags().BoolVarP(&list, "list", "l", false, "allows you to supply the name of a file containing a list of domain names instead of just one domain")
+	rootCmd.PersistentFlags().StringVar(&branches, "branches", "", "file containing additional branch names to guess, one per line")
+	rootCmd.PersistentFlags().StringVar(&tags, "tags", "", "file containing additional tag names to guess, one per line")
+	rootCmd.PersistentFlags().StringVar(&remotes, "remotes", "", "file containing additional remote names to guess, one per line")
+	rootCmd.PersistentFlags().StringVar(&files, "files", "", "file containing additional worktree files to fetch, one per line")
+	rootCmd.PersistentFlags().BoolVar(&indexCommit, "index-commit", false, "writes the commit of the index to refs/goop/index even if nothing is staged")
+	rootCmd.PersistentFlags().BoolVar(&placeholders, "placeholders", false, "writes placeholders in place of files whose contents couldn't be recovered instead of skipping them")
+	rootCmd.PersistentFlags().IntVar(&tagMisses, "tag-misses", goop.TagMissLimit, "consecutive missing versions after which guessing tags next to known versions stops")
 }
 
 func Execute() {
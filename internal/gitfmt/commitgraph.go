@@ -0,0 +1,68 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrMalformedCommitGraph = errors.New("malformed commit graph")
+	ErrMalformedChunkTable  = errors.New("malformed chunk table")
+)
+
+var commitGraphSignature = []byte{'C', 'G', 'P', 'H'}
+
+// CommitGraph holds the commits listed in a commit-graph file together with
+// their root trees.
+type CommitGraph struct {
+	Commits []string
+	Trees   []string
+}
+
+func DecodeCommitGraph(data []byte, f ObjectFormat) (*CommitGraph, error) {
+	if len(data) < 8 || !bytes.Equal(data[:4], commitGraphSignature) {
+		return nil, ErrMalformedCommitGraph
+	}
+	chunks, err := readChunkTable(data, 8, int(data[6]))
+	if err != nil {
+		return nil, err
+	}
+	oidl, ok := chunks["OIDL"]
+	if !ok {
+		return nil, ErrMalformedCommitGraph
+	}
+	cdat := chunks["CDAT"]
+
+	graph := &CommitGraph{}
+	n := len(oidl) / f.Size()
+	for i := 0; i < n; i++ {
+		graph.Commits = append(graph.Commits, hex.EncodeToString(oidl[i*f.Size():(i+1)*f.Size()]))
+		entry := i * (f.Size() + 16)
+		if entry+f.Size() <= len(cdat) {
+			graph.Trees = append(graph.Trees, hex.EncodeToString(cdat[entry:entry+f.Size()]))
+		}
+	}
+	return graph, nil
+}
+
+// readChunkTable reads the table of contents shared by the commit-graph and
+// multi-pack-index formats, returning the data of every chunk by id.
+func readChunkTable(data []byte, start, count int) (map[string][]byte, error) {
+	if len(data) < start+(count+1)*12 {
+		return nil, ErrMalformedChunkTable
+	}
+	chunks := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		e := start + i*12
+		id := string(data[e : e+4])
+		off := binary.BigEndian.Uint64(data[e+4 : e+12])
+		end := binary.BigEndian.Uint64(data[e+16 : e+24])
+		if off > end || end > uint64(len(data)) {
+			return nil, ErrMalformedChunkTable
+		}
+		chunks[id] = data[off:end]
+	}
+	return chunks, nil
+}
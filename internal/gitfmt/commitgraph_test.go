@@ -0,0 +1,114 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+type testChunk struct {
+	id   string
+	data []byte
+}
+
+// encodeChunkFile writes header, the table of contents of chunks, the chunks
+// and the trailing checksum, setting the chunk count at countAt in the header.
+func encodeChunkFile(f ObjectFormat, header []byte, countAt int, chunks []testChunk) []byte {
+	header = append([]byte{}, header...)
+	header[countAt] = byte(len(chunks))
+	var buf bytes.Buffer
+	buf.Write(header)
+	offset := uint64(len(header) + (len(chunks)+1)*12)
+	for _, c := range chunks {
+		buf.WriteString(c.id)
+		binary.Write(&buf, binary.BigEndian, offset)
+		offset += uint64(len(c.data))
+	}
+	buf.Write([]byte{0, 0, 0, 0})
+	binary.Write(&buf, binary.BigEndian, offset)
+	for _, c := range chunks {
+		buf.Write(c.data)
+	}
+	h := f.New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	return buf.Bytes()
+}
+
+func testHashes(f ObjectFormat, n int) []string {
+	var hashes []string
+	for i := 1; i <= n; i++ {
+		hashes = append(hashes, hex.EncodeToString(bytes.Repeat([]byte{byte(i)}, f.Size())))
+	}
+	return hashes
+}
+
+func rawHashes(hashes ...string) []byte {
+	var raw []byte
+	for _, h := range hashes {
+		b, _ := hex.DecodeString(h)
+		raw = append(raw, b...)
+	}
+	return raw
+}
+
+func TestDecodeCommitGraph(t *testing.T) {
+	header := []byte{'C', 'G', 'P', 'H', 1, 1, 0, 0}
+	for _, f := range []ObjectFormat{SHA1, SHA256} {
+		t.Run(string(f), func(t *testing.T) {
+			hashes := testHashes(f, 4)
+			commits, trees := hashes[:2], hashes[2:]
+			var cdat []byte
+			for _, tree := range trees {
+				cdat = append(cdat, rawHashes(tree)...)
+				cdat = append(cdat, make([]byte, 16)...)
+			}
+			chunks := []testChunk{
+				{"OIDF", make([]byte, 256*4)},
+				{"OIDL", rawHashes(commits...)},
+				{"CDAT", cdat},
+			}
+			graph, err := DecodeCommitGraph(encodeChunkFile(f, header, 6, chunks), f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(graph.Commits, commits) || !reflect.DeepEqual(graph.Trees, trees) {
+				t.Errorf("got commits %v and trees %v", graph.Commits, graph.Trees)
+			}
+		})
+	}
+}
+
+func TestDecodeCommitGraphMalformed(t *testing.T) {
+	header := []byte{'C', 'G', 'P', 'H', 1, 1, 0, 0}
+	valid := encodeChunkFile(SHA1, header, 6, []testChunk{{"OIDL", rawHashes(testHashes(SHA1, 1)...)}})
+	tooManyChunks := append([]byte{}, valid...)
+	tooManyChunks[6] = 200
+	pastEnd := append([]byte{}, valid...)
+	// the end of the chunk is the offset of the terminating entry
+	binary.BigEndian.PutUint64(pastEnd[8+12+4:], uint64(len(valid)+1))
+	backwards := append([]byte{}, valid...)
+	binary.BigEndian.PutUint64(backwards[8+4:], uint64(len(valid)))
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"signature", append([]byte("CGPX"), valid[4:]...), ErrMalformedCommitGraph},
+		{"short", valid[:6], ErrMalformedCommitGraph},
+		{"chunk count", tooManyChunks, ErrMalformedChunkTable},
+		{"chunk past the end", pastEnd, ErrMalformedChunkTable},
+		{"chunk ending before it starts", backwards, ErrMalformedChunkTable},
+		{"no object ids", encodeChunkFile(SHA1, header, 6, []testChunk{{"CDAT", nil}}), ErrMalformedCommitGraph},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeCommitGraph(tt.data, SHA1); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
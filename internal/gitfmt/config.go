@@ -0,0 +1,97 @@
+package gitfmt
+
+import (
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Remote is a [remote "name"] section of a git config.
+type Remote struct {
+	Name     string
+	Urls     []string
+	PushUrls []string
+	Fetch    []string
+}
+
+// ParseRemotes reads the remotes configured in the contents of a git config file.
+func ParseRemotes(config []byte) ([]Remote, error) {
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true, AllowShadows: true}, config)
+	if err != nil {
+		return nil, err
+	}
+	var remotes []Remote
+	for _, section := range cfg.Sections() {
+		fields := strings.SplitN(section.Name(), " ", 2)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "remote") {
+			continue
+		}
+		r := Remote{Name: strings.Trim(strings.TrimSpace(fields[1]), `"`)}
+		for _, key := range section.Keys() {
+			switch strings.ToLower(key.Name()) {
+			case "url":
+				r.Urls = append(r.Urls, key.ValueWithShadows()...)
+			case "pushurl":
+				r.PushUrls = append(r.PushUrls, key.ValueWithShadows()...)
+			case "fetch":
+				r.Fetch = append(r.Fetch, key.ValueWithShadows()...)
+			}
+		}
+		remotes = append(remotes, r)
+	}
+	return remotes, nil
+}
+
+// SameUrl compares two remote urls, ignoring a trailing slash or .git suffix.
+func SameUrl(a, b string) bool {
+	normalize := func(u string) string {
+		u = strings.TrimSuffix(strings.TrimSpace(u), "/")
+		return strings.TrimSuffix(u, ".git")
+	}
+	return normalize(a) != "" && normalize(a) == normalize(b)
+}
+
+// ConfigIncludes returns the paths of the files pulled in by [include] and
+// [includeIf] sections, regardless of their condition.
+func ConfigIncludes(config []byte) []string {
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true, AllowShadows: true}, config)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, section := range cfg.Sections() {
+		name := strings.ToLower(section.Name())
+		if name != "include" && !strings.HasPrefix(name, "includeif ") {
+			continue
+		}
+		for _, key := range section.Keys() {
+			if strings.EqualFold(key.Name(), "path") {
+				paths = append(paths, key.ValueWithShadows()...)
+			}
+		}
+	}
+	return paths
+}
+
+// MapRefspec maps ref through a fetch refspec like +refs/heads/*:refs/remotes/origin/*,
+// returning the ref it is stored as or false if the refspec doesn't match.
+func MapRefspec(spec, ref string) (string, bool) {
+	spec = strings.TrimPrefix(spec, "+")
+	if strings.HasPrefix(spec, "^") {
+		return "", false
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", false
+	}
+	src, dst := parts[0], parts[1]
+	star := strings.IndexByte(src, '*')
+	if star < 0 {
+		return dst, src == ref
+	}
+	prefix, suffix := src[:star], src[star+1:]
+	if !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) || len(ref) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	return strings.Replace(dst, "*", ref[len(prefix):len(ref)-len(suffix)], 1), true
+}
@@ -0,0 +1,97 @@
+package gitfmt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapRefspec(t *testing.T) {
+	tests := []struct {
+		spec, ref string
+		want      string
+		ok        bool
+	}{
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/main", "refs/remotes/origin/main", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/heads/feature/x", "refs/remotes/origin/feature/x", true},
+		{"+refs/heads/*:refs/remotes/origin/*", "refs/tags/v1.0", "", false},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/main", "refs/remotes/origin/main", true},
+		{"refs/heads/main:refs/remotes/origin/main", "refs/heads/dev", "", false},
+		{"refs/heads/feature/*-wip:refs/remotes/wip/*", "refs/heads/feature/x-wip", "refs/remotes/wip/x", true},
+		{"refs/heads/feature/*-wip:refs/remotes/wip/*", "refs/heads/feature/x", "", false},
+		// prefix and suffix can't overlap
+		{"refs/heads/a*a:refs/remotes/origin/*", "refs/heads/a", "", false},
+		{"^refs/heads/secret", "refs/heads/secret", "", false},
+		{"refs/heads/*", "refs/heads/main", "", false},
+		{"refs/heads/*:", "refs/heads/main", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec+" "+tt.ref, func(t *testing.T) {
+			got, ok := MapRefspec(tt.spec, tt.ref)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "include and includeIf",
+			config: `[core]
+	bare = false
+[include]
+	path = a.inc
+	path = b.inc
+[includeIf "gitdir:~/work/"]
+	path = ../work.inc
+[user]
+	path = not-an-include
+`,
+			want: []string{"a.inc", "b.inc", "../work.inc"},
+		},
+		{"mixed case", "[Include]\n\tPath = c.inc\n", []string{"c.inc"}},
+		{"none", "[core]\n\tbare = true\n", nil},
+		{"broken", "[include\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConfigIncludes([]byte(tt.config)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRemotes(t *testing.T) {
+	config := `[remote "origin"]
+	url = https://example.com/repo.git
+	url = https://mirror.example.com/repo.git
+	pushurl = git@example.com:repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+[remote "upstream"]
+	url = https://example.com/upstream
+[branch "main"]
+	remote = origin
+`
+	remotes, err := ParseRemotes([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Remote{
+		{
+			Name:     "origin",
+			Urls:     []string{"https://example.com/repo.git", "https://mirror.example.com/repo.git"},
+			PushUrls: []string{"git@example.com:repo.git"},
+			Fetch:    []string{"+refs/heads/*:refs/remotes/origin/*"},
+		},
+		{Name: "upstream", Urls: []string{"https://example.com/upstream"}},
+	}
+	if !reflect.DeepEqual(remotes, want) {
+		t.Errorf("got %+v, want %+v", remotes, want)
+	}
+}
@@ -0,0 +1,59 @@
+package gitfmt
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMalformedBitmap = errors.New("malformed ewah bitmap")
+
+// decodeEWAH decodes an EWAH compressed bitmap as used by the index, returning
+// the positions of all set bits and the number of bytes consumed. Bitmaps with
+// bits set past their size or past maxBits are rejected, as a single run word
+// can stand for billions of bits.
+func decodeEWAH(data []byte, maxBits int) ([]int, int, error) {
+	if len(data) < 8 {
+		return nil, 0, ErrMalformedBitmap
+	}
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	words := int(binary.BigEndian.Uint32(data[4:8]))
+	end := 8 + words*8 + 4
+	if words < 0 || len(data) < end {
+		return nil, 0, ErrMalformedBitmap
+	}
+	if maxBits < size {
+		size = maxBits
+	}
+
+	var bits []int
+	pos := 0
+	for i := 0; i < words; {
+		rlw := binary.BigEndian.Uint64(data[8+i*8:])
+		i++
+		runLen := int((rlw >> 1) & 0xffffffff)
+		literals := int(rlw >> 33)
+		if rlw&1 != 0 && runLen > 0 {
+			if pos+runLen*64 > size {
+				return nil, 0, ErrMalformedBitmap
+			}
+			for b := 0; b < runLen*64; b++ {
+				bits = append(bits, pos+b)
+			}
+		}
+		pos += runLen * 64
+		for l := 0; l < literals && i < words; l++ {
+			word := binary.BigEndian.Uint64(data[8+i*8:])
+			i++
+			for b := 0; b < 64; b++ {
+				if word&(1<<uint(b)) != 0 {
+					if pos+b >= size {
+						return nil, 0, ErrMalformedBitmap
+					}
+					bits = append(bits, pos+b)
+				}
+			}
+			pos += 64
+		}
+	}
+	return bits, end, nil
+}
@@ -0,0 +1,67 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// encodeEWAH writes a bitmap of size bits made of the given words.
+func encodeEWAH(size uint32, words ...uint64) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, size)
+	binary.Write(&buf, binary.BigEndian, uint32(len(words)))
+	for _, w := range words {
+		binary.Write(&buf, binary.BigEndian, w)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+// rlw builds a run length word of runLen words of bit followed by literals.
+func rlw(bit bool, runLen, literals uint64) uint64 {
+	w := runLen<<1 | literals<<33
+	if bit {
+		w |= 1
+	}
+	return w
+}
+
+func TestDecodeEWAH(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		maxBits int
+		bits    []int
+		err     error
+	}{
+		{"literal", encodeEWAH(10, rlw(false, 0, 1), 1<<1|1<<9), 10, []int{1, 9}, nil},
+		{"runs", encodeEWAH(192, rlw(false, 1, 0), rlw(true, 1, 1), 1), 192, bitRange(64, 128), nil},
+		{"empty", encodeEWAH(0), 0, nil, nil},
+		{"bit past size", encodeEWAH(10, rlw(false, 0, 1), 1<<10), 100, nil, ErrMalformedBitmap},
+		{"bit past max", encodeEWAH(100, rlw(false, 0, 1), 1<<10), 5, nil, ErrMalformedBitmap},
+		{"huge run", encodeEWAH(1<<31, rlw(true, 1<<32-1, 0)), 3, nil, ErrMalformedBitmap},
+		{"truncated", encodeEWAH(64, rlw(false, 0, 1), 1)[:12], 64, nil, ErrMalformedBitmap},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bits, n, err := decodeEWAH(tt.data, tt.maxBits)
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && (!reflect.DeepEqual(bits, tt.bits) || n != len(tt.data)) {
+				t.Errorf("got %v (%d bytes), want %v (%d bytes)", bits, n, tt.bits, len(tt.data))
+			}
+		})
+	}
+}
+
+// bitRange lists the positions from start up to and including end.
+func bitRange(start, end int) []int {
+	var bits []int
+	for i := start; i <= end; i++ {
+		bits = append(bits, i)
+	}
+	return bits
+}
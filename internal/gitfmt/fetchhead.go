@@ -0,0 +1,43 @@
+package gitfmt
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// FetchHeadEntry is a line of FETCH_HEAD. Kind is "branch" or "tag" for named
+// refs, empty if the name is a full ref or if the remote HEAD was fetched.
+type FetchHeadEntry struct {
+	Hash        string
+	NotForMerge bool
+	Kind        string
+	Name        string
+	Url         string
+}
+
+func ParseFetchHead(content []byte) []FetchHeadEntry {
+	var entries []FetchHeadEntry
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		e := FetchHeadEntry{Hash: fields[0], NotForMerge: fields[1] == "not-for-merge"}
+		desc := fields[2]
+		if i := strings.LastIndex(desc, "' of "); i >= 0 {
+			e.Url = desc[i+len("' of "):]
+			desc = desc[:i]
+			if sp := strings.Index(desc, " '"); sp >= 0 && !strings.HasPrefix(desc, "'") {
+				e.Kind = desc[:sp]
+				desc = desc[sp+1:]
+			}
+			e.Name = strings.TrimPrefix(desc, "'")
+		} else {
+			e.Url = desc
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,45 @@
+package gitfmt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFetchHead(t *testing.T) {
+	hash := emptyBlobSHA1
+	tests := []struct {
+		name string
+		line string
+		want []FetchHeadEntry
+	}{
+		{
+			name: "branch",
+			line: hash + "\t\tbranch 'main' of https://example.com/repo",
+			want: []FetchHeadEntry{{Hash: hash, Kind: "branch", Name: "main", Url: "https://example.com/repo"}},
+		},
+		{
+			name: "tag",
+			line: hash + "\tnot-for-merge\ttag 'v1.0' of https://example.com/repo",
+			want: []FetchHeadEntry{{Hash: hash, NotForMerge: true, Kind: "tag", Name: "v1.0", Url: "https://example.com/repo"}},
+		},
+		{
+			name: "full ref",
+			line: hash + "\tnot-for-merge\t'refs/pull/1/head' of https://example.com/repo",
+			want: []FetchHeadEntry{{Hash: hash, NotForMerge: true, Name: "refs/pull/1/head", Url: "https://example.com/repo"}},
+		},
+		{
+			name: "remote head",
+			line: hash + "\t\thttps://example.com/repo",
+			want: []FetchHeadEntry{{Hash: hash, Url: "https://example.com/repo"}},
+		},
+		{"missing fields", hash + " branch 'main'", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseFetchHead([]byte(tt.line + "\n")); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
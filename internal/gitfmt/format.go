@@ -0,0 +1,83 @@
+// Package gitfmt implements hash-agnostic readers and writers for the on-disk
+// formats goop has to deal with. go-git only understands sha1 repositories, so
+// everything that has to work for sha256 repositories as well lives here.
+package gitfmt
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// ObjectFormat is the hash algorithm a repository uses to name its objects.
+type ObjectFormat string
+
+const (
+	SHA1   ObjectFormat = "sha1"
+	SHA256 ObjectFormat = "sha256"
+)
+
+// ParseObjectFormat reads extensions.objectFormat from the contents of a git
+// config file, defaulting to sha1 just like git does.
+func ParseObjectFormat(config []byte) ObjectFormat {
+	cfg, err := ini.LoadSources(ini.LoadOptions{Insensitive: true, IgnoreInlineComment: true}, config)
+	if err != nil {
+		return SHA1
+	}
+	if strings.EqualFold(cfg.Section("extensions").Key("objectformat").String(), string(SHA256)) {
+		return SHA256
+	}
+	return SHA1
+}
+
+// Size returns the length of a raw hash in bytes.
+func (f ObjectFormat) Size() int {
+	if f == SHA256 {
+		return sha256.Size
+	}
+	return sha1.Size
+}
+
+// HexSize returns the length of a hex encoded hash.
+func (f ObjectFormat) HexSize() int {
+	return f.Size() * 2
+}
+
+// New returns a new hash.Hash computing hashes in this format.
+func (f ObjectFormat) New() hash.Hash {
+	if f == SHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// ZeroHash returns the all zero hash used by git to denote "no object".
+func (f ObjectFormat) ZeroHash() string {
+	return strings.Repeat("0", f.HexSize())
+}
+
+// IsHash reports whether s is a valid lowercase hex hash in this format.
+func (f ObjectFormat) IsHash(s string) bool {
+	if len(s) != f.HexSize() {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ObjectHash computes the name of an object with the given type and content.
+func (f ObjectFormat) ObjectHash(typ ObjectType, content []byte) string {
+	h := f.New()
+	h.Write(objectHeader(typ, len(content)))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,43 @@
+package gitfmt
+
+import (
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+type Submodule struct {
+	Name string
+	Path string
+	Url  string
+}
+
+// ParseGitmodules reads the submodules declared in a .gitmodules file. The
+// submodule name is kept case sensitive since it names the directory the
+// submodule repository lives in.
+func ParseGitmodules(content []byte) ([]Submodule, error) {
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, content)
+	if err != nil {
+		return nil, err
+	}
+	var modules []Submodule
+	for _, section := range cfg.Sections() {
+		fields := strings.SplitN(section.Name(), " ", 2)
+		if len(fields) != 2 || !strings.EqualFold(fields[0], "submodule") {
+			continue
+		}
+		m := Submodule{Name: strings.Trim(strings.TrimSpace(fields[1]), `"`)}
+		for _, key := range section.Keys() {
+			switch strings.ToLower(key.Name()) {
+			case "path":
+				m.Path = strings.Trim(key.Value(), "/")
+			case "url":
+				m.Url = key.Value()
+			}
+		}
+		if m.Name != "" && m.Path != "" {
+			modules = append(modules, m)
+		}
+	}
+	return modules, nil
+}
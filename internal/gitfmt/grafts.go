@@ -0,0 +1,71 @@
+package gitfmt
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// Grafts overrides the parents of commits. Shallow commits are grafts without
+// any parents, as the history beyond them doesn't exist in the repository.
+type Grafts map[string][]string
+
+// ParseShallow reads the commits listed in .git/shallow.
+func ParseShallow(data []byte, f ObjectFormat) Grafts {
+	grafts := make(Grafts)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		hash := strings.TrimSpace(scanner.Text())
+		if f.IsHash(hash) {
+			grafts[hash] = nil
+		}
+	}
+	return grafts
+}
+
+// ParseGrafts reads info/grafts, where each line lists a commit followed by its parents.
+func ParseGrafts(data []byte, f ObjectFormat) Grafts {
+	grafts := make(Grafts)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !f.IsHash(fields[0]) {
+			continue
+		}
+		var parents []string
+		for _, p := range fields[1:] {
+			if f.IsHash(p) {
+				parents = append(parents, p)
+			}
+		}
+		grafts[fields[0]] = parents
+	}
+	return grafts
+}
+
+// Merge adds all grafts from other, overriding existing ones.
+func (g Grafts) Merge(other Grafts) {
+	for hash, parents := range other {
+		g[hash] = parents
+	}
+}
+
+// ReferencedHashes is like the package level ReferencedHashes, but follows the
+// grafted parents instead of the recorded ones for grafted commits.
+func (g Grafts) ReferencedHashes(f ObjectFormat, hash string, typ ObjectType, content []byte) []string {
+	parents, ok := g[hash]
+	if typ != CommitObject || !ok {
+		return ReferencedHashes(f, typ, content)
+	}
+	var hashes []string
+	forEachHeader(content, func(key, value []byte) {
+		if string(key) == "tree" {
+			hashes = append(hashes, string(value))
+		}
+	})
+	return append(hashes, parents...)
+}
@@ -0,0 +1,208 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+var (
+	ErrMalformedIndex     = errors.New("malformed index")
+	ErrUnsupportedVersion = errors.New("unsupported index version")
+	ErrEntryNotFound      = errors.New("entry not found in index")
+)
+
+var indexSignature = []byte{'D', 'I', 'R', 'C'}
+
+const (
+	entryExtended    = 0x4000
+	entryNameMask    = 0xfff
+	intentToAddMask  = 1 << 13
+	skipWorktreeMask = 1 << 14
+)
+
+type Index struct {
+	Version    uint32
+	Format     ObjectFormat
+	Entries    []*IndexEntry
+	Extensions []IndexExtension
+	Checksum   string
+}
+
+type IndexEntry struct {
+	Name         string
+	Hash         string
+	Mode         uint32
+	UID          uint32
+	GID          uint32
+	Size         uint32
+	CreatedAt    time.Time
+	ModifiedAt   time.Time
+	Stage        int
+	IntentToAdd  bool
+	SkipWorktree bool
+}
+
+type IndexExtension struct {
+	Signature string
+	Data      []byte
+}
+
+func ReadIndex(path string, f ObjectFormat) (*Index, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeIndex(data, f)
+}
+
+// DecodeIndex parses a git index of version 2 to 4. Unknown extensions are
+// kept around as raw data so callers can make sense of them.
+func DecodeIndex(data []byte, f ObjectFormat) (*Index, error) {
+	if len(data) < 12+f.Size() || !bytes.Equal(data[:4], indexSignature) {
+		return nil, ErrMalformedIndex
+	}
+	idx := &Index{
+		Version: binary.BigEndian.Uint32(data[4:8]),
+		Format:  f,
+	}
+	if idx.Version < 2 || idx.Version > 4 {
+		return nil, ErrUnsupportedVersion
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+
+	body := data[:len(data)-f.Size()]
+	idx.Checksum = hex.EncodeToString(data[len(data)-f.Size():])
+
+	pos := 12
+	var lastName string
+	for i := 0; i < count; i++ {
+		e, n, err := decodeIndexEntry(body[pos:], idx.Version, f, lastName)
+		if err != nil {
+			return idx, err
+		}
+		idx.Entries = append(idx.Entries, e)
+		lastName = e.Name
+		pos += n
+	}
+
+	for len(body)-pos >= 8 {
+		size := int(binary.BigEndian.Uint32(body[pos+4 : pos+8]))
+		if pos+8+size > len(body) {
+			return idx, ErrMalformedIndex
+		}
+		idx.Extensions = append(idx.Extensions, IndexExtension{
+			Signature: string(body[pos : pos+4]),
+			Data:      body[pos+8 : pos+8+size],
+		})
+		pos += 8 + size
+	}
+	return idx, nil
+}
+
+func decodeIndexEntry(data []byte, version uint32, f ObjectFormat, lastName string) (*IndexEntry, int, error) {
+	headerLen := 40 + f.Size() + 2
+	if len(data) < headerLen {
+		return nil, 0, ErrMalformedIndex
+	}
+	u32 := func(i int) uint32 {
+		return binary.BigEndian.Uint32(data[i*4 : i*4+4])
+	}
+	e := &IndexEntry{
+		Mode: u32(6),
+		UID:  u32(7),
+		GID:  u32(8),
+		Size: u32(9),
+		Hash: hex.EncodeToString(data[40 : 40+f.Size()]),
+	}
+	if sec, nsec := u32(0), u32(1); sec != 0 || nsec != 0 {
+		e.CreatedAt = time.Unix(int64(sec), int64(nsec))
+	}
+	if sec, nsec := u32(2), u32(3); sec != 0 || nsec != 0 {
+		e.ModifiedAt = time.Unix(int64(sec), int64(nsec))
+	}
+	flags := binary.BigEndian.Uint16(data[headerLen-2 : headerLen])
+	e.Stage = int(flags>>12) & 0x3
+
+	pos := headerLen
+	if flags&entryExtended != 0 {
+		if len(data) < pos+2 {
+			return nil, 0, ErrMalformedIndex
+		}
+		extended := binary.BigEndian.Uint16(data[pos : pos+2])
+		e.IntentToAdd = extended&intentToAddMask != 0
+		e.SkipWorktree = extended&skipWorktreeMask != 0
+		pos += 2
+	}
+
+	if version == 4 {
+		strip, n := readOffsetVarint(data[pos:])
+		if n <= 0 || int(strip) > len(lastName) {
+			return nil, 0, ErrMalformedIndex
+		}
+		pos += n
+		nul := bytes.IndexByte(data[pos:], 0)
+		if nul < 0 {
+			return nil, 0, ErrMalformedIndex
+		}
+		e.Name = lastName[:len(lastName)-int(strip)] + string(data[pos:pos+nul])
+		return e, pos + nul + 1, nil
+	}
+
+	nameLen := int(flags & entryNameMask)
+	if nameLen == entryNameMask {
+		nameLen = bytes.IndexByte(data[pos:], 0)
+	}
+	if nameLen < 0 || len(data) < pos+nameLen {
+		return nil, 0, ErrMalformedIndex
+	}
+	e.Name = string(data[pos : pos+nameLen])
+	pos += nameLen
+	// entries are padded with 1-8 nul bytes to a multiple of eight bytes
+	pos += 8 - pos%8
+	if pos > len(data) {
+		return nil, 0, ErrMalformedIndex
+	}
+	return e, pos, nil
+}
+
+// readOffsetVarint reads the offset encoded integers used by index v4 and
+// ofs-delta pack entries.
+func readOffsetVarint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	c := data[0]
+	v := uint64(c & 0x7f)
+	n := 1
+	for c&0x80 != 0 {
+		if n >= len(data) {
+			return 0, 0
+		}
+		c = data[n]
+		n++
+		v = ((v + 1) << 7) | uint64(c&0x7f)
+	}
+	return v, n
+}
+
+func (i *Index) Entry(name string) (*IndexEntry, error) {
+	for _, e := range i.Entries {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return nil, ErrEntryNotFound
+}
+
+func (i *Index) Extension(signature string) []byte {
+	for _, ext := range i.Extensions {
+		if ext.Signature == signature {
+			return ext.Data
+		}
+	}
+	return nil
+}
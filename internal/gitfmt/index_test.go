@@ -0,0 +1,175 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	emptyBlobSHA1   = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+	emptyBlobSHA256 = "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813"
+)
+
+// encodeIndex writes entries and extensions the way git would for the given
+// index version.
+func encodeIndex(f ObjectFormat, version uint32, entries []*IndexEntry, exts ...IndexExtension) []byte {
+	var buf bytes.Buffer
+	buf.Write(indexSignature)
+	binary.Write(&buf, binary.BigEndian, version)
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	var lastName string
+	for _, e := range entries {
+		start := buf.Len()
+		for _, t := range []time.Time{e.CreatedAt, e.ModifiedAt} {
+			var sec, nsec uint32
+			if !t.IsZero() {
+				sec, nsec = uint32(t.Unix()), uint32(t.Nanosecond())
+			}
+			binary.Write(&buf, binary.BigEndian, sec)
+			binary.Write(&buf, binary.BigEndian, nsec)
+		}
+		for _, v := range []uint32{0, 0, e.Mode, e.UID, e.GID, e.Size} {
+			binary.Write(&buf, binary.BigEndian, v)
+		}
+		raw, _ := hex.DecodeString(e.Hash)
+		buf.Write(raw)
+		flags := uint16(e.Stage)<<12 | uint16(len(e.Name))
+		if len(e.Name) >= entryNameMask {
+			flags = uint16(e.Stage)<<12 | entryNameMask
+		}
+		extended := e.IntentToAdd || e.SkipWorktree
+		if extended {
+			flags |= entryExtended
+		}
+		binary.Write(&buf, binary.BigEndian, flags)
+		if extended {
+			var ext uint16
+			if e.IntentToAdd {
+				ext |= intentToAddMask
+			}
+			if e.SkipWorktree {
+				ext |= skipWorktreeMask
+			}
+			binary.Write(&buf, binary.BigEndian, ext)
+		}
+		if version == 4 {
+			common := 0
+			for common < len(lastName) && common < len(e.Name) && lastName[common] == e.Name[common] {
+				common++
+			}
+			buf.Write(encodeOffsetVarint(uint64(len(lastName) - common)))
+			buf.WriteString(e.Name[common:])
+			buf.WriteByte(0)
+			lastName = e.Name
+			continue
+		}
+		buf.WriteString(e.Name)
+		pad := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, pad))
+	}
+	for _, ext := range exts {
+		buf.WriteString(ext.Signature)
+		binary.Write(&buf, binary.BigEndian, uint32(len(ext.Data)))
+		buf.Write(ext.Data)
+	}
+	h := f.New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	return buf.Bytes()
+}
+
+// encodeOffsetVarint is the inverse of readOffsetVarint.
+func encodeOffsetVarint(v uint64) []byte {
+	out := []byte{byte(v & 0x7f)}
+	for v >>= 7; v > 0; v >>= 7 {
+		v--
+		out = append([]byte{byte(0x80 | v&0x7f)}, out...)
+	}
+	return out
+}
+
+func TestDecodeIndex(t *testing.T) {
+	modified := time.Unix(1600000000, 5)
+	entries := func(hash string) []*IndexEntry {
+		return []*IndexEntry{
+			{Name: "README.md", Hash: hash, Mode: 0100644, Size: 0, ModifiedAt: modified},
+			{Name: "src/main.go", Hash: hash, Mode: 0100755},
+			{Name: "src/main_test.go", Hash: hash, Mode: 0100644, Stage: 2},
+			{Name: "src/util.go", Hash: hash, Mode: 0100644, IntentToAdd: true},
+			{Name: "vendor/" + strings.Repeat("x", 5000), Hash: hash, Mode: 0120000, SkipWorktree: true},
+		}
+	}
+	tests := []struct {
+		name    string
+		format  ObjectFormat
+		version uint32
+	}{
+		{"v2 sha1", SHA1, 2},
+		{"v3 sha1", SHA1, 3},
+		{"v4 sha1", SHA1, 4},
+		{"v2 sha256", SHA256, 2},
+		{"v4 sha256", SHA256, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := emptyBlobSHA1
+			if tt.format == SHA256 {
+				hash = emptyBlobSHA256
+			}
+			want := entries(hash)
+			ext := IndexExtension{Signature: "ABCD", Data: []byte("data")}
+			idx, err := DecodeIndex(encodeIndex(tt.format, tt.version, want, ext), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idx.Version != tt.version || len(idx.Entries) != len(want) {
+				t.Fatalf("got version %d with %d entries", idx.Version, len(idx.Entries))
+			}
+			for i, e := range idx.Entries {
+				w := want[i]
+				if e.Name != w.Name || e.Hash != w.Hash || e.Mode != w.Mode || e.Stage != w.Stage ||
+					e.IntentToAdd != w.IntentToAdd || e.SkipWorktree != w.SkipWorktree || !e.ModifiedAt.Equal(w.ModifiedAt) {
+					t.Errorf("entry %d: got %+v, want %+v", i, e, w)
+				}
+			}
+			if string(idx.Extension("ABCD")) != "data" {
+				t.Errorf("extension: got %q", idx.Extension("ABCD"))
+			}
+		})
+	}
+}
+
+func TestDecodeIndexMalformed(t *testing.T) {
+	valid := encodeIndex(SHA1, 2, []*IndexEntry{{Name: "a", Hash: emptyBlobSHA1, Mode: 0100644}})
+	v4 := encodeIndex(SHA1, 4, []*IndexEntry{{Name: "a", Hash: emptyBlobSHA1, Mode: 0100644}})
+	// the first entry can't strip anything from the previous name
+	badStrip := append([]byte{}, v4...)
+	badStrip[12+62] = 5
+
+	version5 := append([]byte{}, valid...)
+	version5[7] = 5
+	manyEntries := append([]byte{}, valid...)
+	manyEntries[11] = 2
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrMalformedIndex},
+		{"signature", append([]byte("DIRX"), valid[4:]...), ErrMalformedIndex},
+		{"version", version5, ErrUnsupportedVersion},
+		{"truncated entries", manyEntries, ErrMalformedIndex},
+		{"v4 prefix", badStrip, ErrMalformedIndex},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeIndex(tt.data, SHA1); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+)
+
+var ErrMalformedExtension = errors.New("malformed index extension")
+
+const (
+	CacheTreeSignature   = "TREE"
+	ResolveUndoSignature = "REUC"
+	UntrackedSignature   = "UNTR"
+	LinkSignature        = "link"
+)
+
+// CacheTreeEntry is a directory recorded in the TREE extension. Invalidated
+// entries have an empty hash.
+type CacheTreeEntry struct {
+	Path     string
+	Entries  int
+	Subtrees int
+	Hash     string
+}
+
+type ResolveUndoEntry struct {
+	Path   string
+	Modes  [3]uint32
+	Hashes [3]string
+}
+
+// IndexLink is the content of the link extension of a split index.
+type IndexLink struct {
+	SharedIndex string
+	// bitmaps of the shared index entries deleted or replaced by this index,
+	// only decoded once the number of shared entries is known
+	bitmaps []byte
+}
+
+// CacheTree decodes the TREE extension, returning entries with their full paths.
+func (i *Index) CacheTree() ([]CacheTreeEntry, error) {
+	data := i.Extension(CacheTreeSignature)
+	if data == nil {
+		return nil, nil
+	}
+	var entries []CacheTreeEntry
+	var parse func(parent string) error
+	parse = func(parent string) error {
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return ErrMalformedExtension
+		}
+		name := string(data[:nul])
+		data = data[nul+1:]
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			return ErrMalformedExtension
+		}
+		counts := bytes.SplitN(data[:nl], []byte{' '}, 2)
+		data = data[nl+1:]
+		if len(counts) != 2 {
+			return ErrMalformedExtension
+		}
+		count, err := strconv.Atoi(string(counts[0]))
+		if err != nil {
+			return ErrMalformedExtension
+		}
+		subtrees, err := strconv.Atoi(string(counts[1]))
+		if err != nil {
+			return ErrMalformedExtension
+		}
+		e := CacheTreeEntry{Path: path.Join(parent, name), Entries: count, Subtrees: subtrees}
+		if count >= 0 {
+			if len(data) < i.Format.Size() {
+				return ErrMalformedExtension
+			}
+			e.Hash = hex.EncodeToString(data[:i.Format.Size()])
+			data = data[i.Format.Size():]
+		}
+		entries = append(entries, e)
+		for s := 0; s < subtrees; s++ {
+			if err := parse(e.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for len(data) > 0 {
+		if err := parse(""); err != nil {
+			return entries, err
+		}
+	}
+	return entries, nil
+}
+
+func (i *Index) ResolveUndo() ([]ResolveUndoEntry, error) {
+	data := i.Extension(ResolveUndoSignature)
+	var entries []ResolveUndoEntry
+	for len(data) > 0 {
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return entries, ErrMalformedExtension
+		}
+		e := ResolveUndoEntry{Path: string(data[:nul])}
+		data = data[nul+1:]
+		for s := 0; s < 3; s++ {
+			nul := bytes.IndexByte(data, 0)
+			if nul < 0 {
+				return entries, ErrMalformedExtension
+			}
+			mode, err := strconv.ParseUint(string(data[:nul]), 8, 32)
+			if err != nil {
+				return entries, ErrMalformedExtension
+			}
+			e.Modes[s] = uint32(mode)
+			data = data[nul+1:]
+		}
+		for s := 0; s < 3; s++ {
+			if e.Modes[s] == 0 {
+				continue
+			}
+			if len(data) < i.Format.Size() {
+				return entries, ErrMalformedExtension
+			}
+			e.Hashes[s] = hex.EncodeToString(data[:i.Format.Size()])
+			data = data[i.Format.Size():]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Untracked returns the paths of all untracked files and directories recorded
+// in the untracked cache extension. Directories end with a slash.
+func (i *Index) Untracked() ([]string, error) {
+	data := i.Extension(UntrackedSignature)
+	if data == nil {
+		return nil, nil
+	}
+	identLen, n := readOffsetVarint(data)
+	if n <= 0 || uint64(len(data)-n) < identLen {
+		return nil, ErrMalformedExtension
+	}
+	data = data[n+int(identLen):]
+
+	// stat data of info/exclude and core.excludesFile, dir_flags and the hashes of both files
+	header := 2*36 + 4 + 2*i.Format.Size()
+	if len(data) < header {
+		return nil, ErrMalformedExtension
+	}
+	data = data[header:]
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return nil, ErrMalformedExtension
+	}
+	data = data[nul+1:]
+
+	dirs, n := readOffsetVarint(data)
+	if n <= 0 {
+		return nil, ErrMalformedExtension
+	}
+	data = data[n:]
+	if dirs == 0 {
+		return nil, nil
+	}
+
+	var paths []string
+	var readString = func() (string, error) {
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return "", ErrMalformedExtension
+		}
+		s := string(data[:nul])
+		data = data[nul+1:]
+		return s, nil
+	}
+	var readDir func(parent string) error
+	readDir = func(parent string) error {
+		untracked, n := readOffsetVarint(data)
+		if n <= 0 {
+			return ErrMalformedExtension
+		}
+		data = data[n:]
+		subdirs, n := readOffsetVarint(data)
+		if n <= 0 {
+			return ErrMalformedExtension
+		}
+		data = data[n:]
+		name, err := readString()
+		if err != nil {
+			return err
+		}
+		dir := parent
+		if name != "" {
+			dir = parent + name + "/"
+		}
+		for u := uint64(0); u < untracked; u++ {
+			entry, err := readString()
+			if err != nil {
+				return err
+			}
+			paths = append(paths, dir+entry)
+		}
+		for s := uint64(0); s < subdirs; s++ {
+			if err := readDir(dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := readDir("")
+	return paths, err
+}
+
+// Link decodes the link extension written by split index mode.
+func (i *Index) Link() (*IndexLink, error) {
+	data := i.Extension(LinkSignature)
+	if data == nil {
+		return nil, nil
+	}
+	if len(data) < i.Format.Size() {
+		return nil, ErrMalformedExtension
+	}
+	return &IndexLink{
+		SharedIndex: hex.EncodeToString(data[:i.Format.Size()]),
+		bitmaps:     data[i.Format.Size():],
+	}, nil
+}
+
+// positions returns the positions of the deleted and replaced shared entries.
+func (l *IndexLink) positions(shared int) ([]int, []int, error) {
+	if len(l.bitmaps) == 0 {
+		return nil, nil, nil
+	}
+	deleted, n, err := decodeEWAH(l.bitmaps, shared)
+	if err != nil {
+		return nil, nil, err
+	}
+	replaced, _, err := decodeEWAH(l.bitmaps[n:], shared)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deleted, replaced, nil
+}
+
+// MergeSharedIndex applies a split index on top of the shared index it links
+// to, returning an index containing the complete set of entries.
+func MergeSharedIndex(split, shared *Index) (*Index, error) {
+	link, err := split.Link()
+	if err != nil {
+		return nil, err
+	}
+	if link == nil {
+		return split, nil
+	}
+	deletePos, replacePos, err := link.positions(len(shared.Entries))
+	if err != nil {
+		return nil, err
+	}
+
+	deleted := make(map[int]bool, len(deletePos))
+	for _, pos := range deletePos {
+		deleted[pos] = true
+	}
+	entries := split.Entries
+	replaced := make(map[int]*IndexEntry, len(replacePos))
+	for _, pos := range replacePos {
+		if len(entries) == 0 || pos >= len(shared.Entries) {
+			return nil, ErrMalformedExtension
+		}
+		// replacements don't carry a name, it is taken from the shared entry
+		e := *entries[0]
+		e.Name = shared.Entries[pos].Name
+		replaced[pos] = &e
+		entries = entries[1:]
+	}
+
+	merged := &Index{
+		Version:  split.Version,
+		Format:   split.Format,
+		Checksum: split.Checksum,
+	}
+	for pos, e := range shared.Entries {
+		if r, ok := replaced[pos]; ok {
+			merged.Entries = append(merged.Entries, r)
+		} else if !deleted[pos] {
+			merged.Entries = append(merged.Entries, e)
+		}
+	}
+	merged.Entries = append(merged.Entries, entries...)
+	sort.SliceStable(merged.Entries, func(a, b int) bool {
+		if merged.Entries[a].Name != merged.Entries[b].Name {
+			return merged.Entries[a].Name < merged.Entries[b].Name
+		}
+		return merged.Entries[a].Stage < merged.Entries[b].Stage
+	})
+
+	for _, ext := range split.Extensions {
+		if ext.Signature != LinkSignature {
+			merged.Extensions = append(merged.Extensions, ext)
+		}
+	}
+	merged.Extensions = append(merged.Extensions, shared.Extensions...)
+	return merged, nil
+}
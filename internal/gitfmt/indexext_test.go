@@ -0,0 +1,199 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// encodeUntracked writes an untracked cache extension around the given
+// directory blocks.
+func encodeUntracked(f ObjectFormat, dirs ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(encodeOffsetVarint(5))
+	buf.WriteString("ident")
+	buf.Write(make([]byte, 2*36+4+2*f.Size()))
+	buf.WriteString(".gitignore\x00")
+	buf.Write(encodeOffsetVarint(uint64(len(dirs))))
+	for _, d := range dirs {
+		buf.Write(d)
+	}
+	return buf.Bytes()
+}
+
+// untrackedDir encodes a directory block of the untracked cache.
+func untrackedDir(name string, subdirs int, untracked ...string) []byte {
+	var buf bytes.Buffer
+	buf.Write(encodeOffsetVarint(uint64(len(untracked))))
+	buf.Write(encodeOffsetVarint(uint64(subdirs)))
+	buf.WriteString(name + "\x00")
+	for _, u := range untracked {
+		buf.WriteString(u + "\x00")
+	}
+	return buf.Bytes()
+}
+
+func TestIndexExtensions(t *testing.T) {
+	hash := emptyBlobSHA1
+	raw := rawHashes(hash)
+	cacheTree := func(i *Index) (interface{}, error) { return i.CacheTree() }
+	resolveUndo := func(i *Index) (interface{}, error) { return i.ResolveUndo() }
+	untracked := func(i *Index) (interface{}, error) { return i.Untracked() }
+
+	tests := []struct {
+		name   string
+		ext    IndexExtension
+		decode func(*Index) (interface{}, error)
+		want   interface{}
+		err    error
+	}{
+		{
+			name: "cache tree",
+			ext: IndexExtension{Signature: CacheTreeSignature, Data: bytes.Join([][]byte{
+				[]byte("\x002 1\n"), raw, []byte("src\x00-1 0\n"),
+			}, nil)},
+			decode: cacheTree,
+			want: []CacheTreeEntry{
+				{Path: "", Entries: 2, Subtrees: 1, Hash: hash},
+				{Path: "src", Entries: -1},
+			},
+		},
+		{
+			name:   "cache tree without hash",
+			ext:    IndexExtension{Signature: CacheTreeSignature, Data: append([]byte("\x002 0\n"), raw[:4]...)},
+			decode: cacheTree,
+			err:    ErrMalformedExtension,
+		},
+		{
+			name: "resolve undo",
+			ext: IndexExtension{Signature: ResolveUndoSignature, Data: bytes.Join([][]byte{
+				[]byte("a.txt\x00100644\x000\x00100755\x00"), raw, raw,
+			}, nil)},
+			decode: resolveUndo,
+			want: []ResolveUndoEntry{
+				{Path: "a.txt", Modes: [3]uint32{0100644, 0, 0100755}, Hashes: [3]string{hash, "", hash}},
+			},
+		},
+		{
+			name:   "resolve undo with bad mode",
+			ext:    IndexExtension{Signature: ResolveUndoSignature, Data: []byte("a.txt\x00rw\x000\x000\x00")},
+			decode: resolveUndo,
+			err:    ErrMalformedExtension,
+		},
+		{
+			name: "untracked",
+			ext: IndexExtension{Signature: UntrackedSignature, Data: encodeUntracked(SHA1,
+				untrackedDir("", 1, "a.txt"),
+				untrackedDir("build", 0, "out.o", "tmp/"),
+			)},
+			decode: untracked,
+			want:   []string{"a.txt", "build/out.o", "build/tmp/"},
+		},
+		{
+			name:   "untracked without directories",
+			ext:    IndexExtension{Signature: UntrackedSignature, Data: encodeUntracked(SHA1)},
+			decode: untracked,
+			want:   []string(nil),
+		},
+		{
+			name:   "truncated untracked",
+			ext:    IndexExtension{Signature: UntrackedSignature, Data: encodeUntracked(SHA1, untrackedDir("", 0, "a.txt"))[:100]},
+			decode: untracked,
+			err:    ErrMalformedExtension,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := DecodeIndex(encodeIndex(SHA1, 2, nil, tt.ext), SHA1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.decode(idx)
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSharedIndex(t *testing.T) {
+	blob := func(c byte) string {
+		return hex.EncodeToString(bytes.Repeat([]byte{c}, SHA1.Size()))
+	}
+	shared, err := DecodeIndex(encodeIndex(SHA1, 2, []*IndexEntry{
+		{Name: "a", Hash: blob(1), Mode: 0100644},
+		{Name: "b", Hash: blob(2), Mode: 0100644},
+		{Name: "c", Hash: blob(3), Mode: 0100644},
+	}), SHA1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := func(del, replace []byte) IndexExtension {
+		raw, _ := hex.DecodeString(shared.Checksum)
+		return IndexExtension{Signature: LinkSignature, Data: append(append(raw, del...), replace...)}
+	}
+	// the replacement of a comes first, without a name, then the added entry
+	splitEntries := []*IndexEntry{
+		{Name: "", Hash: blob(4), Mode: 0100755},
+		{Name: "d", Hash: blob(5), Mode: 0100644},
+	}
+
+	tests := []struct {
+		name  string
+		link  IndexExtension
+		names []string
+		err   error
+	}{
+		{
+			name:  "delete and replace",
+			link:  link(encodeEWAH(3, rlw(false, 0, 1), 1<<1), encodeEWAH(3, rlw(false, 0, 1), 1<<0)),
+			names: []string{"a", "c", "d"},
+		},
+		{
+			name: "deleted entry out of range",
+			link: link(encodeEWAH(64, rlw(false, 0, 1), 1<<5), encodeEWAH(3, rlw(false, 0, 1), 1<<0)),
+			err:  ErrMalformedBitmap,
+		},
+		{
+			name: "run of ones",
+			link: link(encodeEWAH(1<<31, rlw(true, 1<<32-1, 0)), encodeEWAH(3)),
+			err:  ErrMalformedBitmap,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			split, err := DecodeIndex(encodeIndex(SHA1, 2, splitEntries, tt.link), SHA1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := split.Link()
+			if err != nil || l.SharedIndex != shared.Checksum {
+				t.Fatalf("got link %+v, %v", l, err)
+			}
+			merged, err := MergeSharedIndex(split, shared)
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			var names []string
+			for _, e := range merged.Entries {
+				names = append(names, e.Name)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("got %v, want %v", names, tt.names)
+			}
+			if e, _ := merged.Entry("a"); e == nil || e.Hash != blob(4) || e.Mode != 0100755 {
+				t.Errorf("replaced entry: got %+v", e)
+			}
+			if merged.Extension(LinkSignature) != nil {
+				t.Error("merged index still has a link extension")
+			}
+		})
+	}
+}
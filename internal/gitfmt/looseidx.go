@@ -0,0 +1,45 @@
+package gitfmt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var ErrMalformedLooseObjectMap = errors.New("malformed loose-object-idx")
+
+const looseObjectMapHeader = "# loose-object-idx"
+
+// LooseObjectMap is the content of objects/loose-object-idx, which maps loose
+// objects to their name in the compatibility object format of a repository.
+type LooseObjectMap struct {
+	Objects []string
+	// Compat maps object names to their compatibility names and Main does the reverse
+	Compat map[string]string
+	Main   map[string]string
+}
+
+func DecodeLooseObjectMap(data []byte, f ObjectFormat) (*LooseObjectMap, error) {
+	m := &LooseObjectMap{
+		Compat: make(map[string]string),
+		Main:   make(map[string]string),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != looseObjectMapHeader {
+		return nil, ErrMalformedLooseObjectMap
+	}
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 || !f.IsHash(fields[0]) {
+			return m, ErrMalformedLooseObjectMap
+		}
+		m.Objects = append(m.Objects, fields[0])
+		m.Compat[fields[0]] = fields[1]
+		m.Main[fields[1]] = fields[0]
+	}
+	return m, scanner.Err()
+}
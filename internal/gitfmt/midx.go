@@ -0,0 +1,46 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var ErrMalformedMultiPackIndex = errors.New("malformed multi-pack-index")
+
+var midxSignature = []byte{'M', 'I', 'D', 'X'}
+
+// MultiPackIndex holds the packs and objects listed in a multi-pack-index file.
+type MultiPackIndex struct {
+	// Packs contains the pack names without extension, e.g. pack-<hash>
+	Packs    []string
+	Objects  []string
+	Checksum string
+}
+
+func DecodeMultiPackIndex(data []byte, f ObjectFormat) (*MultiPackIndex, error) {
+	if len(data) < 12+f.Size() || !bytes.Equal(data[:4], midxSignature) {
+		return nil, ErrMalformedMultiPackIndex
+	}
+	chunks, err := readChunkTable(data, 12, int(data[6]))
+	if err != nil {
+		return nil, err
+	}
+
+	midx := &MultiPackIndex{
+		Checksum: hex.EncodeToString(data[len(data)-f.Size():]),
+	}
+	for _, name := range bytes.Split(chunks["PNAM"], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		pack := strings.TrimSuffix(strings.TrimSuffix(string(name), ".idx"), ".pack")
+		midx.Packs = append(midx.Packs, pack)
+	}
+	oidl := chunks["OIDL"]
+	for i := 0; i+f.Size() <= len(oidl); i += f.Size() {
+		midx.Objects = append(midx.Objects, hex.EncodeToString(oidl[i:i+f.Size()]))
+	}
+	return midx, nil
+}
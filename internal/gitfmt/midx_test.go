@@ -0,0 +1,60 @@
+package gitfmt
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMultiPackIndex(t *testing.T) {
+	header := []byte{'M', 'I', 'D', 'X', 1, 1, 0, 0, 0, 0, 0, 2}
+	for _, f := range []ObjectFormat{SHA1, SHA256} {
+		t.Run(string(f), func(t *testing.T) {
+			objects := testHashes(f, 3)
+			data := encodeChunkFile(f, header, 6, []testChunk{
+				// names are padded to a multiple of four bytes
+				{"PNAM", []byte("pack-a.idx\x00pack-b.idx\x00\x00\x00")},
+				{"OIDF", make([]byte, 256*4)},
+				{"OIDL", rawHashes(objects...)},
+				{"OOFF", make([]byte, 8*len(objects))},
+			})
+			midx, err := DecodeMultiPackIndex(data, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"pack-a", "pack-b"}; !reflect.DeepEqual(midx.Packs, want) {
+				t.Errorf("packs: got %v, want %v", midx.Packs, want)
+			}
+			if !reflect.DeepEqual(midx.Objects, objects) {
+				t.Errorf("objects: got %v, want %v", midx.Objects, objects)
+			}
+			if want := hex.EncodeToString(data[len(data)-f.Size():]); midx.Checksum != want {
+				t.Errorf("checksum: got %s, want %s", midx.Checksum, want)
+			}
+		})
+	}
+}
+
+func TestDecodeMultiPackIndexMalformed(t *testing.T) {
+	header := []byte{'M', 'I', 'D', 'X', 1, 1, 0, 0, 0, 0, 0, 1}
+	valid := encodeChunkFile(SHA1, header, 6, []testChunk{{"PNAM", []byte("pack-a.idx\x00\x00")}})
+	tooManyChunks := append([]byte{}, valid...)
+	tooManyChunks[6] = 100
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"signature", append([]byte("MIDY"), valid[4:]...), ErrMalformedMultiPackIndex},
+		{"short", valid[:20], ErrMalformedMultiPackIndex},
+		{"chunk count", tooManyChunks, ErrMalformedChunkTable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeMultiPackIndex(tt.data, SHA1); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package gitfmt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type ObjectType string
+
+const (
+	CommitObject ObjectType = "commit"
+	TreeObject   ObjectType = "tree"
+	BlobObject   ObjectType = "blob"
+	TagObject    ObjectType = "tag"
+)
+
+var ErrMalformedObject = errors.New("malformed object")
+
+func objectHeader(typ ObjectType, size int) []byte {
+	return []byte(fmt.Sprintf("%s %d\x00", typ, size))
+}
+
+// LooseObjectPath returns the path of a loose object relative to the git directory.
+func LooseObjectPath(hash string) string {
+	return fmt.Sprintf("objects/%s/%s", hash[:2], hash[2:])
+}
+
+func ReadLooseObject(path string) (ObjectType, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return DecodeLooseObject(data)
+}
+
+// DecodeLooseObject inflates a loose object and splits it into its type and content.
+func DecodeLooseObject(data []byte) (ObjectType, []byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	nul := bytes.IndexByte(raw, 0)
+	if nul < 0 {
+		return "", nil, ErrMalformedObject
+	}
+	header := bytes.SplitN(raw[:nul], []byte{' '}, 2)
+	if len(header) != 2 {
+		return "", nil, ErrMalformedObject
+	}
+	size, err := strconv.Atoi(string(header[1]))
+	if err != nil || size != len(raw)-nul-1 {
+		return "", nil, ErrMalformedObject
+	}
+	typ := ObjectType(header[0])
+	switch typ {
+	case CommitObject, TreeObject, BlobObject, TagObject:
+	default:
+		return "", nil, ErrMalformedObject
+	}
+	return typ, raw[nul+1:], nil
+}
+
+// WriteLooseObject stores an object in gitDir unless it already exists and returns its hash.
+func WriteLooseObject(gitDir string, f ObjectFormat, typ ObjectType, content []byte) (string, error) {
+	hash := f.ObjectHash(typ, content)
+	path := filepath.Join(gitDir, LooseObjectPath(hash))
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(objectHeader(typ, len(content)))
+	zw.Write(content)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0444); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp, path)
+}
+
+// ForEachLooseObject calls fn with the hash of every loose object in gitDir.
+func ForEachLooseObject(gitDir string, f ObjectFormat, fn func(hash string) error) error {
+	objDir := filepath.Join(gitDir, "objects")
+	dirs, err := ioutil.ReadDir(objDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(objDir, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			hash := dir.Name() + file.Name()
+			if file.IsDir() || !f.IsHash(hash) {
+				continue
+			}
+			if err := fn(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ReferencedHashes returns the hashes of all objects directly referenced by an object.
+func ReferencedHashes(f ObjectFormat, typ ObjectType, content []byte) []string {
+	var hashes []string
+	switch typ {
+	case CommitObject:
+		forEachHeader(content, func(key, value []byte) {
+			if string(key) == "tree" || string(key) == "parent" {
+				hashes = append(hashes, string(value))
+			}
+		})
+	case TagObject:
+		forEachHeader(content, func(key, value []byte) {
+			if string(key) == "object" {
+				hashes = append(hashes, string(value))
+			}
+		})
+	case TreeObject:
+		for _, e := range ParseTree(f, content) {
+			hashes = append(hashes, e.Hash)
+		}
+	}
+	return hashes
+}
+
+// Commit holds the parts of a commit goop cares about.
+type Commit struct {
+	Tree    string
+	Parents []string
+	// Time is when the commit was committed
+	Time    time.Time
+	Message string
+}
+
+func ParseCommit(content []byte) *Commit {
+	c := &Commit{}
+	forEachHeader(content, func(key, value []byte) {
+		switch string(key) {
+		case "tree":
+			c.Tree = string(value)
+		case "parent":
+			c.Parents = append(c.Parents, string(value))
+		case "committer":
+			// the timestamp and time zone follow the committer's name and email
+			fields := bytes.Fields(value)
+			if len(fields) >= 2 {
+				if ts, err := strconv.ParseInt(string(fields[len(fields)-2]), 10, 64); err == nil {
+					c.Time = time.Unix(ts, 0).UTC()
+				}
+			}
+		}
+	})
+	if i := bytes.Index(content, []byte("\n\n")); i >= 0 {
+		c.Message = string(content[i+2:])
+	}
+	return c
+}
+
+type TreeEntry struct {
+	Mode uint32
+	Name string
+	Hash string
+}
+
+// ParseTree decodes the content of a tree object, stopping at the first malformed entry.
+func ParseTree(f ObjectFormat, content []byte) []TreeEntry {
+	var entries []TreeEntry
+	for len(content) > 0 {
+		sp := bytes.IndexByte(content, ' ')
+		if sp < 0 {
+			break
+		}
+		nul := bytes.IndexByte(content[sp:], 0)
+		if nul < 0 || sp+nul+1+f.Size() > len(content) {
+			break
+		}
+		nul += sp
+		mode, err := strconv.ParseUint(string(content[:sp]), 8, 32)
+		if err != nil {
+			break
+		}
+		entries = append(entries, TreeEntry{
+			Mode: uint32(mode),
+			Name: string(content[sp+1 : nul]),
+			Hash: hex.EncodeToString(content[nul+1 : nul+1+f.Size()]),
+		})
+		content = content[nul+1+f.Size():]
+	}
+	return entries
+}
+
+// forEachHeader calls fn for every header line of a commit or tag, skipping
+// continuation lines of multi-line headers such as gpgsig.
+func forEachHeader(content []byte, fn func(key, value []byte)) {
+	for len(content) > 0 {
+		nl := bytes.IndexByte(content, '\n')
+		if nl < 0 {
+			nl = len(content)
+		}
+		line := content[:nl]
+		if len(line) == 0 {
+			return
+		}
+		if line[0] != ' ' {
+			parts := bytes.SplitN(line, []byte{' '}, 2)
+			if len(parts) == 2 {
+				fn(parts[0], parts[1])
+			}
+		}
+		if nl == len(content) {
+			return
+		}
+		content = content[nl+1:]
+	}
+}
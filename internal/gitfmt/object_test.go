@@ -0,0 +1,56 @@
+package gitfmt
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCommit(t *testing.T) {
+	tree := emptyBlobSHA1
+	parent := "5e4fb1e6bd1402ea1fa7b9d15bdf8c55f5e2b3e6"
+	tests := []struct {
+		name    string
+		content string
+		want    Commit
+	}{
+		{
+			name: "committer time",
+			content: "tree " + tree + "\n" +
+				"parent " + parent + "\n" +
+				"author A U Thor <author@example.com> 1500000000 +0000\n" +
+				"committer C O Mitter <committer@example.com> 1600000000 +0200\n" +
+				"\nsubject\n\nbody\n",
+			want: Commit{Tree: tree, Parents: []string{parent}, Time: time.Unix(1600000000, 0).UTC(), Message: "subject\n\nbody\n"},
+		},
+		{
+			name: "merge with signature",
+			content: "tree " + tree + "\n" +
+				"parent " + parent + "\n" +
+				"parent " + tree + "\n" +
+				"committer Name With Spaces <c@example.com> 1600000001 -0700\n" +
+				"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+				" parent " + tree + "\n" +
+				" -----END PGP SIGNATURE-----\n" +
+				"\nmerge\n",
+			want: Commit{Tree: tree, Parents: []string{parent, tree}, Time: time.Unix(1600000001, 0).UTC(), Message: "merge\n"},
+		},
+		{
+			name:    "no committer",
+			content: "tree " + tree + "\n\nmessage\n",
+			want:    Commit{Tree: tree, Message: "message\n"},
+		},
+		{
+			name:    "broken timestamp",
+			content: "tree " + tree + "\ncommitter C <c@example.com> soon +0000\n",
+			want:    Commit{Tree: tree},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseCommit([]byte(tt.content)); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
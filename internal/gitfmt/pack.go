@@ -0,0 +1,387 @@
+package gitfmt
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	ErrMalformedPack   = errors.New("malformed pack")
+	ErrMalformedDelta  = errors.New("malformed delta")
+	ErrUnresolvedDelta = errors.New("couldn't resolve delta base")
+)
+
+var packSignature = []byte{'P', 'A', 'C', 'K'}
+
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+
+	// upper bound for the amount of inflated objects kept around as delta bases
+	maxPackCacheSize = 64 << 20
+	// git doesn't write delta chains longer than this
+	maxDeltaDepth = 4095
+)
+
+var packTypes = map[byte]ObjectType{
+	packCommit: CommitObject,
+	packTree:   TreeObject,
+	packBlob:   BlobObject,
+	packTag:    TagObject,
+}
+
+type packEntry struct {
+	typ        byte
+	dataOffset int64
+	baseOffset int64
+	baseHash   string
+}
+
+type resolvedObject struct {
+	typ     ObjectType
+	content []byte
+}
+
+// PackObjectFunc is called for every object contained in a pack.
+type PackObjectFunc func(hash string, typ ObjectType, content []byte) error
+
+// ExternalObjectFunc looks up ref-delta bases that aren't part of the pack itself.
+type ExternalObjectFunc func(hash string) (ObjectType, []byte, error)
+
+type packScanner struct {
+	r        *os.File
+	format   ObjectFormat
+	external ExternalObjectFunc
+	entries  map[int64]*packEntry
+	offsets  map[string]int64
+	cache    map[int64]resolvedObject
+	cached   int
+	// entries whose delta chain is being resolved
+	resolving map[int64]bool
+}
+
+// ScanPack reads every object in a pack file, resolving deltas, and calls fn
+// for each of them. Objects whose delta base can't be found are skipped and
+// counted in the returned number.
+func ScanPack(path string, f ObjectFormat, external ExternalObjectFunc, fn PackObjectFunc) (int, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	s := &packScanner{
+		r:         r,
+		format:    f,
+		external:  external,
+		entries:   make(map[int64]*packEntry),
+		offsets:   make(map[string]int64),
+		cache:     make(map[int64]resolvedObject),
+		resolving: make(map[int64]bool),
+	}
+
+	var deltas []int64
+	if err := s.readEntries(func(offset int64, e *packEntry, content []byte) error {
+		if e.typ == packOfsDelta || e.typ == packRefDelta {
+			deltas = append(deltas, offset)
+			return nil
+		}
+		hash := f.ObjectHash(packTypes[e.typ], content)
+		s.offsets[hash] = offset
+		return fn(hash, packTypes[e.typ], content)
+	}); err != nil {
+		return 0, err
+	}
+
+	// ref-delta bases may be deltas themselves, so keep going as long as we make progress
+	var unresolved int
+	for pending := deltas; len(pending) > 0; {
+		var next []int64
+		for _, offset := range pending {
+			obj, err := s.resolve(offset)
+			if err == ErrUnresolvedDelta {
+				next = append(next, offset)
+				continue
+			} else if err != nil {
+				return unresolved, err
+			}
+			hash := f.ObjectHash(obj.typ, obj.content)
+			s.offsets[hash] = offset
+			if err := fn(hash, obj.typ, obj.content); err != nil {
+				return unresolved, err
+			}
+		}
+		if len(next) == len(pending) {
+			unresolved = len(next)
+			break
+		}
+		pending = next
+	}
+	return unresolved, nil
+}
+
+func (s *packScanner) readEntries(fn func(offset int64, e *packEntry, content []byte) error) error {
+	cr := &countingReader{r: bufio.NewReader(s.r)}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(cr, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:4], packSignature) {
+		return ErrMalformedPack
+	}
+	count := binary.BigEndian.Uint32(header[8:12])
+
+	for i := uint32(0); i < count; i++ {
+		offset := cr.n
+		e, err := s.readEntryHeader(cr, offset)
+		if err != nil {
+			return err
+		}
+		content, err := inflate(cr)
+		if err != nil {
+			return err
+		}
+		s.entries[offset] = e
+		if err := fn(offset, e, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readEntry parses the header of the entry at offset, for packs that are read
+// through their index rather than scanned.
+func (s *packScanner) readEntry(offset int64) (*packEntry, error) {
+	if e, ok := s.entries[offset]; ok {
+		return e, nil
+	}
+	cr := &countingReader{r: bufio.NewReader(io.NewSectionReader(s.r, offset, 1<<62)), n: offset}
+	e, err := s.readEntryHeader(cr, offset)
+	if err != nil {
+		return nil, err
+	}
+	s.entries[offset] = e
+	return e, nil
+}
+
+func (s *packScanner) readEntryHeader(cr *countingReader, offset int64) (*packEntry, error) {
+	c, err := cr.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	e := &packEntry{typ: (c >> 4) & 0x7}
+	for c&0x80 != 0 {
+		if c, err = cr.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch e.typ {
+	case packCommit, packTree, packBlob, packTag:
+	case packOfsDelta:
+		rel, err := readOffsetVarintFrom(cr)
+		if err != nil {
+			return nil, err
+		}
+		e.baseOffset = offset - int64(rel)
+		if rel == 0 || e.baseOffset <= 0 || e.baseOffset >= offset {
+			return nil, ErrMalformedPack
+		}
+	case packRefDelta:
+		base := make([]byte, s.format.Size())
+		if _, err := io.ReadFull(cr, base); err != nil {
+			return nil, err
+		}
+		e.baseHash = hex.EncodeToString(base)
+	default:
+		return nil, ErrMalformedPack
+	}
+	e.dataOffset = cr.n
+	return e, nil
+}
+
+func (s *packScanner) resolve(offset int64) (resolvedObject, error) {
+	if obj, ok := s.cache[offset]; ok {
+		return obj, nil
+	}
+	// chains that lead back to themselves or are deeper than any git writes
+	// are malformed
+	if s.resolving[offset] || len(s.resolving) > maxDeltaDepth {
+		return resolvedObject{}, ErrMalformedPack
+	}
+	s.resolving[offset] = true
+	defer delete(s.resolving, offset)
+
+	e, err := s.readEntry(offset)
+	if err != nil {
+		return resolvedObject{}, ErrMalformedPack
+	}
+	data, err := inflate(bufio.NewReader(io.NewSectionReader(s.r, e.dataOffset, 1<<62)))
+	if err != nil {
+		return resolvedObject{}, err
+	}
+
+	var obj resolvedObject
+	switch e.typ {
+	case packOfsDelta, packRefDelta:
+		var base resolvedObject
+		if e.typ == packOfsDelta {
+			base, err = s.resolve(e.baseOffset)
+		} else if baseOffset, ok := s.offsets[e.baseHash]; ok {
+			base, err = s.resolve(baseOffset)
+		} else if s.external != nil {
+			base.typ, base.content, err = s.external(e.baseHash)
+			if err != nil {
+				err = ErrUnresolvedDelta
+			}
+		} else {
+			err = ErrUnresolvedDelta
+		}
+		if err != nil {
+			return resolvedObject{}, err
+		}
+		content, err := ApplyDelta(base.content, data)
+		if err != nil {
+			return resolvedObject{}, err
+		}
+		obj = resolvedObject{typ: base.typ, content: content}
+	default:
+		obj = resolvedObject{typ: packTypes[e.typ], content: data}
+	}
+
+	if s.cached+len(obj.content) > maxPackCacheSize {
+		s.cache = make(map[int64]resolvedObject)
+		s.cached = 0
+	}
+	s.cache[offset] = obj
+	s.cached += len(obj.content)
+	return obj, nil
+}
+
+// ApplyDelta reconstructs an object from its base and a git delta.
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, n := readSizeVarint(delta)
+	if n <= 0 || srcSize != uint64(len(base)) {
+		return nil, ErrMalformedDelta
+	}
+	delta = delta[n:]
+	dstSize, n := readSizeVarint(delta)
+	if n <= 0 {
+		return nil, ErrMalformedDelta
+	}
+	delta = delta[n:]
+
+	// the size is only trusted once the result matches it
+	var out []byte
+	if dstSize <= maxPackCacheSize {
+		out = make([]byte, 0, dstSize)
+	}
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+		switch {
+		case cmd&0x80 != 0:
+			var offset, size uint64
+			for i := uint(0); i < 7; i++ {
+				if cmd&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, ErrMalformedDelta
+				}
+				if i < 4 {
+					offset |= uint64(delta[0]) << (8 * i)
+				} else {
+					size |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, ErrMalformedDelta
+			}
+			out = append(out, base[offset:offset+size]...)
+		case cmd != 0:
+			if int(cmd) > len(delta) {
+				return nil, ErrMalformedDelta
+			}
+			out = append(out, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, ErrMalformedDelta
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, ErrMalformedDelta
+	}
+	return out, nil
+}
+
+func readSizeVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i, c := range data {
+		v |= uint64(c&0x7f) << (7 * uint(i))
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func readOffsetVarintFrom(r io.ByteReader) (uint64, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(c & 0x7f)
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		v = ((v + 1) << 7) | uint64(c&0x7f)
+	}
+	return v, nil
+}
+
+// inflate reads a single zlib stream from r. Since r is a byte reader the
+// decompressor doesn't read past the end of the stream.
+func inflate(r io.Reader) ([]byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
@@ -0,0 +1,268 @@
+package gitfmt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testPackEntry struct {
+	typ     byte
+	content []byte
+	// offset of the ofs-delta base relative to the entry, hash of the ref-delta base
+	rel      uint64
+	baseHash string
+}
+
+// encodePack writes a version 2 pack, returning it with the offset of every entry.
+func encodePack(f ObjectFormat, entries []testPackEntry) ([]byte, []int64) {
+	var buf bytes.Buffer
+	buf.Write(packSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+	var offsets []int64
+	for _, e := range entries {
+		offsets = append(offsets, int64(buf.Len()))
+		size := len(e.content)
+		c := e.typ<<4 | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			buf.WriteByte(c | 0x80)
+			c = byte(size & 0x7f)
+		}
+		buf.WriteByte(c)
+		switch e.typ {
+		case packOfsDelta:
+			buf.Write(encodeOffsetVarint(e.rel))
+		case packRefDelta:
+			raw, _ := hex.DecodeString(e.baseHash)
+			buf.Write(raw)
+		}
+		zw := zlib.NewWriter(&buf)
+		zw.Write(e.content)
+		zw.Close()
+	}
+	h := f.New()
+	h.Write(buf.Bytes())
+	buf.Write(h.Sum(nil))
+	return buf.Bytes(), offsets
+}
+
+func encodeSizeVarint(v uint64) []byte {
+	var out []byte
+	for v >= 0x80 {
+		out = append(out, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(out, byte(v))
+}
+
+// encodeDelta builds a delta turning a srcSize base into dstSize bytes.
+func encodeDelta(srcSize, dstSize uint64, ops ...[]byte) []byte {
+	delta := append(encodeSizeVarint(srcSize), encodeSizeVarint(dstSize)...)
+	for _, op := range ops {
+		delta = append(delta, op...)
+	}
+	return delta
+}
+
+func copyOp(offset, size uint32) []byte {
+	op := []byte{0x80}
+	for i := uint(0); i < 4; i++ {
+		if b := byte(offset >> (8 * i)); b != 0 {
+			op[0] |= 1 << i
+			op = append(op, b)
+		}
+	}
+	for i := uint(0); i < 3; i++ {
+		if b := byte(size >> (8 * i)); b != 0 {
+			op[0] |= 1 << (4 + i)
+			op = append(op, b)
+		}
+	}
+	return op
+}
+
+func insertOp(data string) []byte {
+	return append([]byte{byte(len(data))}, data...)
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanPack(t *testing.T) {
+	for _, f := range []ObjectFormat{SHA1, SHA256} {
+		t.Run(string(f), func(t *testing.T) {
+			base := []byte("hello world\n")
+			more := []byte("hello world\nand more\n")
+			external := []byte("ext")
+			baseHash := f.ObjectHash(BlobObject, base)
+			moreHash := f.ObjectHash(BlobObject, more)
+			externalHash := f.ObjectHash(BlobObject, external)
+
+			entries := []testPackEntry{
+				{typ: packBlob, content: base},
+				{typ: packOfsDelta, content: encodeDelta(12, 21, copyOp(0, 12), insertOp("and more\n"))},
+				{typ: packRefDelta, baseHash: baseHash, content: encodeDelta(12, 6, copyOp(6, 6))},
+				// the base of this one is a delta itself
+				{typ: packRefDelta, baseHash: moreHash, content: encodeDelta(21, 5, copyOp(16, 5))},
+				{typ: packRefDelta, baseHash: externalHash, content: encodeDelta(3, 4, copyOp(0, 3), insertOp("!"))},
+				{typ: packRefDelta, baseHash: f.ZeroHash(), content: encodeDelta(1, 1, insertOp("?"))},
+			}
+			// entries before the ofs-delta don't move once its offset is filled in
+			_, offsets := encodePack(f, entries)
+			entries[1].rel = uint64(offsets[1] - offsets[0])
+			pack, _ := encodePack(f, entries)
+			path := filepath.Join(t.TempDir(), "pack-test.pack")
+			writeTestFile(t, path, pack)
+
+			got := make(map[string]string)
+			unresolved, err := ScanPack(path, f, func(hash string) (ObjectType, []byte, error) {
+				if hash == externalHash {
+					return BlobObject, external, nil
+				}
+				return "", nil, ErrUnresolvedDelta
+			}, func(hash string, typ ObjectType, content []byte) error {
+				if typ != BlobObject {
+					t.Errorf("%s: got type %s", hash, typ)
+				}
+				got[hash] = string(content)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if unresolved != 1 {
+				t.Errorf("got %d unresolved deltas, want 1", unresolved)
+			}
+			for _, want := range []string{"hello world\n", "hello world\nand more\n", "world\n", "more\n", "ext!"} {
+				if hash := f.ObjectHash(BlobObject, []byte(want)); got[hash] != want {
+					t.Errorf("%q: got %q", want, got[hash])
+				}
+			}
+			if len(got) != 5 {
+				t.Errorf("got %d objects, want 5", len(got))
+			}
+		})
+	}
+}
+
+func TestScanPackMalformed(t *testing.T) {
+	blob := testPackEntry{typ: packBlob, content: []byte("base")}
+	delta := encodeDelta(4, 4, copyOp(0, 4))
+	tests := []struct {
+		name    string
+		entries []testPackEntry
+	}{
+		{"ofs-delta on itself", []testPackEntry{blob, {typ: packOfsDelta, rel: 0, content: delta}}},
+		{"ofs-delta before the pack", []testPackEntry{blob, {typ: packOfsDelta, rel: 1 << 20, content: delta}}},
+		{"unknown type", []testPackEntry{{typ: 5, content: []byte("x")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack, _ := encodePack(SHA1, tt.entries)
+			path := filepath.Join(t.TempDir(), "pack-test.pack")
+			writeTestFile(t, path, pack)
+			_, err := ScanPack(path, SHA1, nil, func(string, ObjectType, []byte) error { return nil })
+			if err != ErrMalformedPack {
+				t.Errorf("got %v, want %v", err, ErrMalformedPack)
+			}
+		})
+	}
+}
+
+func TestResolveDeltaChain(t *testing.T) {
+	chain := []testPackEntry{{typ: packBlob, content: []byte("x")}}
+	for len(chain) <= maxDeltaDepth+1 {
+		chain = append(chain, testPackEntry{typ: packOfsDelta, content: encodeDelta(1, 1, insertOp("x"))})
+	}
+	_, offsets := encodePack(SHA1, chain)
+	for i := 1; i < len(chain); i++ {
+		chain[i].rel = uint64(offsets[i] - offsets[i-1])
+	}
+
+	tests := []struct {
+		name    string
+		entries []testPackEntry
+		err     error
+	}{
+		{"short chain", chain[:10], nil},
+		{"longest chain", chain[:maxDeltaDepth+1], nil},
+		{"chain too deep", chain, ErrMalformedPack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pack, offsets := encodePack(SHA1, tt.entries)
+			path := filepath.Join(t.TempDir(), "pack-test.pack")
+			writeTestFile(t, path, pack)
+			r, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			// nothing is cached, so the whole chain is resolved at once
+			s := &packScanner{
+				r:         r,
+				format:    SHA1,
+				entries:   make(map[int64]*packEntry),
+				offsets:   make(map[string]int64),
+				cache:     make(map[int64]resolvedObject),
+				resolving: make(map[int64]bool),
+			}
+			if err := s.readEntries(func(int64, *packEntry, []byte) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+			obj, err := s.resolve(offsets[len(offsets)-1])
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && string(obj.content) != "x" {
+				t.Errorf("got %q", obj.content)
+			}
+		})
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("0123456789")
+	tests := []struct {
+		name  string
+		delta []byte
+		want  string
+		err   error
+	}{
+		{"copy and insert", encodeDelta(10, 7, copyOp(2, 3), insertOp("abcd")), "234abcd", nil},
+		{"copy at offset zero", encodeDelta(10, 10, copyOp(0, 10)), "0123456789", nil},
+		{"base size mismatch", encodeDelta(9, 1, copyOp(0, 1)), "", ErrMalformedDelta},
+		{"copy past the base", encodeDelta(10, 5, copyOp(8, 5)), "", ErrMalformedDelta},
+		{"truncated insert", encodeDelta(10, 5, []byte{5, 'a'}), "", ErrMalformedDelta},
+		{"truncated copy", encodeDelta(10, 5, []byte{0x91}), "", ErrMalformedDelta},
+		{"reserved opcode", encodeDelta(10, 1, []byte{0}), "", ErrMalformedDelta},
+		{"result size mismatch", encodeDelta(10, 4, copyOp(0, 3)), "", ErrMalformedDelta},
+		{"huge result size", encodeDelta(10, 1<<62, copyOp(0, 3)), "", ErrMalformedDelta},
+		{"truncated header", []byte{0x8a}, "", ErrMalformedDelta},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyDelta(base, tt.delta)
+			if err != tt.err {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
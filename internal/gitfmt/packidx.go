@@ -0,0 +1,71 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+var ErrMalformedPackIndex = errors.New("malformed pack index")
+
+var packIdxSignature = []byte{0xff, 't', 'O', 'c'}
+
+// DecodePackIndex maps the hashes listed in a pack .idx file to their offsets
+// in the pack. Both version 1 and version 2 indexes are supported.
+func DecodePackIndex(data []byte, f ObjectFormat) (map[string]int64, error) {
+	if len(data) >= 8 && bytes.Equal(data[:4], packIdxSignature) {
+		if binary.BigEndian.Uint32(data[4:8]) != 2 {
+			return nil, ErrMalformedPackIndex
+		}
+		return decodePackIndexV2(data[8:], f)
+	}
+	return decodePackIndexV1(data, f)
+}
+
+func decodePackIndexV1(data []byte, f ObjectFormat) (map[string]int64, error) {
+	if len(data) < 256*4 {
+		return nil, ErrMalformedPackIndex
+	}
+	count := int(binary.BigEndian.Uint32(data[255*4:]))
+	data = data[256*4:]
+	entrySize := 4 + f.Size()
+	if len(data) < count*entrySize {
+		return nil, ErrMalformedPackIndex
+	}
+	offsets := make(map[string]int64, count)
+	for i := 0; i < count; i++ {
+		entry := data[i*entrySize : (i+1)*entrySize]
+		offsets[hex.EncodeToString(entry[4:])] = int64(binary.BigEndian.Uint32(entry[:4]))
+	}
+	return offsets, nil
+}
+
+func decodePackIndexV2(data []byte, f ObjectFormat) (map[string]int64, error) {
+	if len(data) < 256*4 {
+		return nil, ErrMalformedPackIndex
+	}
+	count := int(binary.BigEndian.Uint32(data[255*4:]))
+	data = data[256*4:]
+	// hashes, crc32s and 32 bit offsets, followed by the 64 bit offset table
+	if len(data) < count*(f.Size()+8) {
+		return nil, ErrMalformedPackIndex
+	}
+	hashes := data[:count*f.Size()]
+	small := data[count*(f.Size()+4) : count*(f.Size()+8)]
+	large := data[count*(f.Size()+8):]
+
+	offsets := make(map[string]int64, count)
+	for i := 0; i < count; i++ {
+		offset := int64(binary.BigEndian.Uint32(small[i*4:]))
+		if offset&0x80000000 != 0 {
+			pos := int(offset&0x7fffffff) * 8
+			if pos+8 > len(large) {
+				return nil, ErrMalformedPackIndex
+			}
+			offset = int64(binary.BigEndian.Uint64(large[pos:]))
+		}
+		offsets[hex.EncodeToString(hashes[i*f.Size():(i+1)*f.Size()])] = offset
+	}
+	return offsets, nil
+}
@@ -0,0 +1,71 @@
+package gitfmt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var ErrRefNotFound = errors.New("ref not found")
+
+// git gives up on symbolic refs nested deeper than this as well
+const maxSymrefDepth = 5
+
+// ResolveRef follows a ref like HEAD or refs/heads/main through symbolic refs
+// and packed-refs to the hash it points at.
+func ResolveRef(gitDir, name string, f ObjectFormat) (string, error) {
+	for depth := 0; depth < maxSymrefDepth; depth++ {
+		content, err := ioutil.ReadFile(filepath.Join(gitDir, name))
+		if err != nil {
+			return packedRef(gitDir, name, f)
+		}
+		value := strings.TrimSpace(string(content))
+		if strings.HasPrefix(value, "ref:") {
+			name = strings.TrimSpace(strings.TrimPrefix(value, "ref:"))
+			continue
+		}
+		if f.IsHash(value) {
+			return value, nil
+		}
+		return "", ErrRefNotFound
+	}
+	return "", ErrRefNotFound
+}
+
+func packedRef(gitDir, name string, f ObjectFormat) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return "", ErrRefNotFound
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) == 2 && parts[1] == name && f.IsHash(parts[0]) {
+			return parts[0], nil
+		}
+	}
+	return "", ErrRefNotFound
+}
+
+// IsRefName reports whether name is a valid ref name by the rules of
+// git check-ref-format, which also keeps it from leaving the git directory.
+func IsRefName(name string) bool {
+	if name == "" || name == "@" || strings.HasSuffix(name, ".") ||
+		strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+	return true
+}
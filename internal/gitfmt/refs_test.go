@@ -0,0 +1,38 @@
+package gitfmt
+
+import "testing"
+
+func TestIsRefName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"refs/heads/main", true},
+		{"refs/heads/feature/x-1.2", true},
+		{"HEAD", true},
+		{"refs/remotes/origin/@", true},
+		{"", false},
+		{"@", false},
+		{"refs/heads/../../config", false},
+		{"refs/heads/./main", false},
+		{"refs/heads/.hidden", false},
+		{"refs/heads/main.", false},
+		{"refs/heads/main.lock", false},
+		{"refs/heads//main", false},
+		{"/refs/heads/main", false},
+		{"refs/heads/", false},
+		{"refs/heads/*", false},
+		{"refs/heads/a b", false},
+		{"refs/heads/a\tb", false},
+		{"refs/heads/a\\b", false},
+		{"refs/heads/main@{1}", false},
+		{"refs/heads/main^2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRefName(tt.name); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
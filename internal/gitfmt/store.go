@@ -0,0 +1,139 @@
+package gitfmt
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Store reads objects from the loose objects and indexed packs of a git directory.
+type Store struct {
+	gitDir string
+	format ObjectFormat
+	packs  []*packScanner
+}
+
+// OpenStore opens every pack in gitDir that has an index next to it. Packs
+// whose index can't be read are ignored.
+func OpenStore(gitDir string, f ObjectFormat) (*Store, error) {
+	s := &Store{gitDir: gitDir, format: f}
+	idxFiles, err := filepath.Glob(filepath.Join(gitDir, "objects", "pack", "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	for _, idxFile := range idxFiles {
+		data, err := ioutil.ReadFile(idxFile)
+		if err != nil {
+			continue
+		}
+		offsets, err := DecodePackIndex(data, f)
+		if err != nil {
+			continue
+		}
+		r, err := os.Open(strings.TrimSuffix(idxFile, ".idx") + ".pack")
+		if err != nil {
+			continue
+		}
+		s.packs = append(s.packs, &packScanner{
+			r:         r,
+			format:    f,
+			external:  s.ReadObject,
+			entries:   make(map[int64]*packEntry),
+			offsets:   offsets,
+			cache:     make(map[int64]resolvedObject),
+			resolving: make(map[int64]bool),
+		})
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	for _, p := range s.packs {
+		p.r.Close()
+	}
+	s.packs = nil
+	return nil
+}
+
+func (s *Store) HasObject(hash string) bool {
+	if _, err := os.Stat(filepath.Join(s.gitDir, LooseObjectPath(hash))); err == nil {
+		return true
+	}
+	for _, p := range s.packs {
+		if _, ok := p.offsets[hash]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ForEachObject calls fn for every loose and packed object, objects stored
+// more than once are passed more than once.
+func (s *Store) ForEachObject(fn func(hash string) error) error {
+	if err := ForEachLooseObject(s.gitDir, s.format, fn); err != nil {
+		return err
+	}
+	for _, p := range s.packs {
+		for hash := range p.offsets {
+			if err := fn(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) ReadObject(hash string) (ObjectType, []byte, error) {
+	if !s.format.IsHash(hash) {
+		return "", nil, ErrObjectNotFound
+	}
+	if typ, content, err := ReadLooseObject(filepath.Join(s.gitDir, LooseObjectPath(hash))); err == nil {
+		return typ, content, nil
+	}
+	for _, p := range s.packs {
+		offset, ok := p.offsets[hash]
+		if !ok {
+			continue
+		}
+		obj, err := p.resolve(offset)
+		if err != nil {
+			return "", nil, err
+		}
+		return obj.typ, obj.content, nil
+	}
+	return "", nil, ErrObjectNotFound
+}
+
+// ReadTree returns the entries of the tree a tree, commit or tag hash points at.
+func (s *Store) ReadTree(hash string) ([]TreeEntry, error) {
+	for {
+		typ, content, err := s.ReadObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case TreeObject:
+			return ParseTree(s.format, content), nil
+		case CommitObject:
+			hash = headerValue(content, "tree")
+		case TagObject:
+			hash = headerValue(content, "object")
+		default:
+			return nil, ErrMalformedObject
+		}
+	}
+}
+
+func headerValue(content []byte, key string) string {
+	var value string
+	forEachHeader(content, func(k, v []byte) {
+		if string(k) == key && value == "" {
+			value = string(v)
+		}
+	})
+	return value
+}
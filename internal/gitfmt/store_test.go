@@ -0,0 +1,85 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// encodePackIndex writes a version 2 pack index mapping hashes to offsets.
+func encodePackIndex(f ObjectFormat, offsets map[string]int64) []byte {
+	var hashes []string
+	for hash := range offsets {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	var buf bytes.Buffer
+	buf.Write(packIdxSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	var fanout [256]uint32
+	for _, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		for i := int(raw[0]); i < 256; i++ {
+			fanout[i]++
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+	for _, hash := range hashes {
+		raw, _ := hex.DecodeString(hash)
+		buf.Write(raw)
+	}
+	buf.Write(make([]byte, 4*len(hashes)))
+	for _, hash := range hashes {
+		binary.Write(&buf, binary.BigEndian, uint32(offsets[hash]))
+	}
+	return buf.Bytes()
+}
+
+func TestStoreDeltaChains(t *testing.T) {
+	target := SHA1.ObjectHash(BlobObject, []byte("target"))
+	chain := []testPackEntry{{typ: packBlob, content: []byte("x")}}
+	for len(chain) <= maxDeltaDepth+1 {
+		chain = append(chain, testPackEntry{typ: packOfsDelta, content: encodeDelta(1, 1, insertOp("x"))})
+	}
+	_, chainOffsets := encodePack(SHA1, chain)
+	for i := 1; i < len(chain); i++ {
+		chain[i].rel = uint64(chainOffsets[i] - chainOffsets[i-1])
+	}
+
+	tests := []struct {
+		name    string
+		entries []testPackEntry
+		// the entry the target hash is mapped to
+		entry int
+		err   error
+	}{
+		{"short chain", chain[:10], 9, nil},
+		{"longest chain", chain[:maxDeltaDepth+1], maxDeltaDepth, nil},
+		// the index maps the base of the ref-delta back onto the delta
+		{"ref-delta cycle", []testPackEntry{{typ: packRefDelta, baseHash: target, content: encodeDelta(1, 1, insertOp("x"))}}, 0, ErrMalformedPack},
+		{"chain too deep", chain, len(chain) - 1, ErrMalformedPack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitDir := t.TempDir()
+			pack, offsets := encodePack(SHA1, tt.entries)
+			writeTestFile(t, filepath.Join(gitDir, "objects/pack/pack-test.pack"), pack)
+			writeTestFile(t, filepath.Join(gitDir, "objects/pack/pack-test.idx"), encodePackIndex(SHA1, map[string]int64{target: offsets[tt.entry]}))
+
+			store, err := OpenStore(gitDir, SHA1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if !store.HasObject(target) {
+				t.Fatal("pack index wasn't read")
+			}
+			if _, _, err := store.ReadObject(target); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
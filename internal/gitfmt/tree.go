@@ -0,0 +1,96 @@
+package gitfmt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const treeMode = 0040000
+
+// EncodeTree encodes entries as the content of a tree object, sorting them
+// the way git does.
+func EncodeTree(f ObjectFormat, entries []TreeEntry) []byte {
+	sorted := append([]TreeEntry{}, entries...)
+	sortKey := func(e TreeEntry) string {
+		if e.Mode == treeMode {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sortKey(sorted[i]) < sortKey(sorted[j])
+	})
+	var buf bytes.Buffer
+	for _, e := range sorted {
+		buf.WriteString(strconv.FormatUint(uint64(e.Mode), 8))
+		buf.WriteByte(' ')
+		buf.WriteString(e.Name)
+		buf.WriteByte(0)
+		raw, _ := hex.DecodeString(e.Hash)
+		buf.Write(raw)
+	}
+	return buf.Bytes()
+}
+
+// IndexTree is a tree object rebuilt from the entries of an index, the root
+// tree having an empty path.
+type IndexTree struct {
+	Path    string
+	Hash    string
+	Content []byte
+}
+
+// IndexTrees rebuilds the trees git write-tree would write for the index,
+// leaving out intent-to-add and unmerged entries. Directories of a sparse
+// index are used as they are.
+func (i *Index) IndexTrees() []IndexTree {
+	entries := map[string][]TreeEntry{"": nil}
+	subdirs := make(map[string][]string)
+	var addDir func(dir string)
+	addDir = func(dir string) {
+		if _, ok := entries[dir]; ok {
+			return
+		}
+		entries[dir] = nil
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		addDir(parent)
+		subdirs[parent] = append(subdirs[parent], dir)
+	}
+	for _, e := range i.Entries {
+		if e.Stage != 0 || e.IntentToAdd {
+			continue
+		}
+		mode := e.Mode
+		name := e.Name
+		if strings.HasSuffix(name, "/") {
+			mode = treeMode
+			name = strings.TrimSuffix(name, "/")
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		addDir(dir)
+		entries[dir] = append(entries[dir], TreeEntry{Mode: mode, Name: base, Hash: e.Hash})
+	}
+
+	var trees []IndexTree
+	var build func(dir string) string
+	build = func(dir string) string {
+		tree := entries[dir]
+		for _, sub := range subdirs[dir] {
+			tree = append(tree, TreeEntry{Mode: treeMode, Name: path.Base(sub), Hash: build(sub)})
+		}
+		content := EncodeTree(i.Format, tree)
+		hash := i.Format.ObjectHash(TreeObject, content)
+		trees = append(trees, IndexTree{Path: dir, Hash: hash, Content: content})
+		return hash
+	}
+	build("")
+	return trees
+}
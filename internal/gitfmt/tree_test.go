@@ -0,0 +1,59 @@
+package gitfmt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeTreeOrder(t *testing.T) {
+	// directories sort as if their name ended in a slash
+	entries := []TreeEntry{
+		{Mode: treeMode, Name: "a", Hash: emptyBlobSHA1},
+		{Mode: 0100644, Name: "b", Hash: emptyBlobSHA1},
+		{Mode: 0100644, Name: "a.txt", Hash: emptyBlobSHA1},
+		{Mode: 0100644, Name: "a0", Hash: emptyBlobSHA1},
+		{Mode: 0100644, Name: "a-b", Hash: emptyBlobSHA1},
+		{Mode: treeMode, Name: "b-dir", Hash: emptyBlobSHA1},
+	}
+	var names []string
+	for _, e := range ParseTree(SHA1, EncodeTree(SHA1, entries)) {
+		names = append(names, e.Name)
+	}
+	if want := []string{"a-b", "a.txt", "a", "a0", "b", "b-dir"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestIndexTrees(t *testing.T) {
+	// the root hashes are what git mktree writes for the same entries
+	tests := []struct {
+		format ObjectFormat
+		blob   string
+		root   string
+	}{
+		{SHA1, emptyBlobSHA1, "5e4fb1e6bd1402ea1fa7b9d15bdf8c55f5e2b3e6"},
+		{SHA256, emptyBlobSHA256, "11eff6e9792debba7647627e2008954ef93d928bd876651449d9fb77a83f381d"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			idx := &Index{Format: tt.format, Entries: []*IndexEntry{
+				{Name: "a-b", Hash: tt.blob, Mode: 0100644},
+				{Name: "a.txt", Hash: tt.blob, Mode: 0100644},
+				{Name: "a/x", Hash: tt.blob, Mode: 0100644},
+				{Name: "b", Hash: tt.blob, Mode: 0120000},
+				{Name: "c", Hash: tt.blob, Mode: 0100755},
+				// neither of these is part of the tree
+				{Name: "d", Hash: tt.blob, Mode: 0100644, IntentToAdd: true},
+				{Name: "e", Hash: tt.blob, Mode: 0100644, Stage: 1},
+			}}
+			trees := idx.IndexTrees()
+			if len(trees) != 2 || trees[0].Path != "a" || trees[1].Path != "" {
+				t.Fatalf("got %+v", trees)
+			}
+			root := trees[1]
+			if root.Hash != tt.root || tt.format.ObjectHash(TreeObject, root.Content) != root.Hash {
+				t.Errorf("got root %s, want %s", root.Hash, tt.root)
+			}
+		})
+	}
+}
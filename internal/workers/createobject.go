@@ -4,18 +4,18 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/deletescape/goop/internal/gitfmt"
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/jobtracker"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/format/index"
-	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/phuslu/log"
 )
 
 type CreateObjectContext struct {
 	BaseDir string
-	Storage *filesystem.ObjectStorage
-	Index   *index.Index
+	GitDir  string
+	Format  gitfmt.ObjectFormat
+	Index   *gitfmt.Index
 }
 
 func CreateObjectWorker(jt *jobtracker.JobTracker, f string, context jobtracker.Context) {
@@ -29,7 +29,7 @@ func CreateObjectWorker(jt *jobtracker.JobTracker, f string, context jobtracker.
 		return
 	}
 
-	fMode, err := entry.Mode.ToOSFileMode()
+	fMode, err := filemode.FileMode(entry.Mode).ToOSFileMode()
 	if err != nil {
 		log.Warn().Str("file", f).Err(err).Msg("failed to set filemode")
 	} else {
@@ -45,26 +45,13 @@ func CreateObjectWorker(jt *jobtracker.JobTracker, f string, context jobtracker.
 		return
 	}
 
-	hash := plumbing.ComputeHash(plumbing.BlobObject, content)
+	hash := c.Format.ObjectHash(gitfmt.BlobObject, content)
 	if entry.Hash != hash {
 		log.Warn().Str("file", f).Msg("hash does not match hash in index, skipping object creation")
 		return
 	}
 
-	obj := c.Storage.NewEncodedObject()
-	obj.SetSize(int64(len(content)))
-	obj.SetType(plumbing.BlobObject)
-
-	ow, err := obj.Writer()
-	if err != nil {
-		log.Error().Str("file", f).Err(err).Msg("failed to create object writer")
-		return
-	}
-	defer ow.Close()
-	ow.Write(content)
-
-	_, err = c.Storage.SetEncodedObject(obj)
-	if err != nil {
+	if _, err := gitfmt.WriteLooseObject(c.GitDir, c.Format, gitfmt.BlobObject, content); err != nil {
 		log.Error().Str("file", f).Err(err).Msg("failed to create object")
 		return
 	}
@@ -1,16 +1,14 @@
 package workers
 
 import (
-	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 
+	"github.com/deletescape/goop/internal/gitfmt"
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/jobtracker"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/phuslu/log"
 	"github.com/valyala/fasthttp"
 )
@@ -18,11 +16,18 @@ import (
 var checkedObjs = make(map[string]bool)
 var checkedObjsMutex sync.Mutex
 
+// BaseUrl and BaseDir point at the git directory itself rather than the worktree
 type FindObjectsContext struct {
 	C       *fasthttp.Client
 	BaseUrl string
 	BaseDir string
-	Storage *filesystem.ObjectStorage
+	Format  gitfmt.ObjectFormat
+	Packed  map[string]bool
+	Grafts  gitfmt.Grafts
+	// Alternates are the urls of object stores used when an object isn't found in the repository
+	Alternates []string
+	// Sources records the alternate each object has been fetched from
+	Sources *sync.Map
 }
 
 func FindObjectsWorker(jt *jobtracker.JobTracker, obj string, context jobtracker.Context) {
@@ -30,62 +35,86 @@ func FindObjectsWorker(jt *jobtracker.JobTracker, obj string, context jobtracker
 
 	checkRatelimted()
 
-	if obj == "" {
+	if !c.Format.IsHash(obj) || obj == c.Format.ZeroHash() {
 		return
 	}
 
 	checkedObjsMutex.Lock()
-	if checked, ok := checkedObjs[obj]; checked && ok {
+	if checked, ok := checkedObjs[c.BaseUrl+obj]; checked && ok {
 		// Obj has already been checked
 		checkedObjsMutex.Unlock()
 		return
 	} else {
-		checkedObjs[obj] = true
+		checkedObjs[c.BaseUrl+obj] = true
 	}
 	checkedObjsMutex.Unlock()
 
-	file := fmt.Sprintf(".git/objects/%s/%s", obj[:2], obj[2:])
+	if c.Packed[obj] {
+		// the objects referenced by packed objects are already queued
+		return
+	}
+
+	file := gitfmt.LooseObjectPath(obj)
 	fullPath := utils.Url(c.BaseDir, file)
 	if utils.Exists(fullPath) {
 		log.Info().Str("obj", obj).Msg("already fetched, skipping redownload")
-		encObj, err := c.Storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(obj))
+		typ, content, err := gitfmt.ReadLooseObject(fullPath)
 		if err != nil {
 			log.Error().Str("obj", obj).Err(err).Msg("couldn't read object")
 			return
 		}
-		decObj, err := object.DecodeObject(c.Storage, encObj)
-		if err != nil {
-			log.Error().Str("obj", obj).Err(err).Msg("couldn't decode object")
-			return
-		}
-		referencedHashes := utils.GetReferencedHashes(decObj)
-		for _, h := range referencedHashes {
-			jt.AddJob(h)
-		}
+		jt.AddJobs(c.Grafts.ReferencedHashes(c.Format, obj, typ, content)...)
 		return
 	}
 
-	uri := utils.Url(c.BaseUrl, file)
-	code, body, err := c.C.Get(nil, uri)
-	if err == nil && code != 200 {
-		if code == 429 {
-			setRatelimited()
-			jt.AddJob(obj)
-			return
+	// fall back to alternate object stores when the object isn't in the repository itself
+	var uri string
+	var body []byte
+	for i, store := range append([]string{c.BaseUrl}, c.Alternates...) {
+		if i == 0 {
+			uri = utils.Url(store, file)
+		} else {
+			uri = utils.Url(store, strings.TrimPrefix(file, "objects/"))
 		}
-		log.Warn().Str("obj", obj).Int("code", code).Msg("failed to fetch object")
-		return
-	} else if err != nil {
-		log.Error().Str("obj", obj).Int("code", code).Err(err).Msg("failed to fetch object")
+		code, b, err := c.C.Get(nil, uri)
+		if err == nil && code != 200 {
+			if code == 429 {
+				setRatelimited()
+				jt.AddJob(obj)
+				return
+			}
+			log.Warn().Str("obj", obj).Str("uri", uri).Int("code", code).Msg("failed to fetch object")
+			continue
+		} else if err != nil {
+			log.Error().Str("obj", obj).Str("uri", uri).Int("code", code).Err(err).Msg("failed to fetch object")
+			continue
+		}
+
+		if utils.IsHtml(b) {
+			log.Warn().Str("uri", uri).Msg("file appears to be html, skipping")
+			continue
+		}
+		if utils.IsEmptyBytes(b) {
+			log.Warn().Str("uri", uri).Msg("file appears to be empty, skipping")
+			continue
+		}
+		if i > 0 && c.Sources != nil {
+			c.Sources.Store(obj, store)
+		}
+		body = b
+		break
+	}
+	if body == nil {
 		return
 	}
 
-	if utils.IsHtml(body) {
-		log.Warn().Str("uri", uri).Msg("file appears to be html, skipping")
+	typ, content, err := gitfmt.DecodeLooseObject(body)
+	if err != nil {
+		log.Warn().Str("uri", uri).Err(err).Msg("couldn't decode object, skipping")
 		return
 	}
-	if utils.IsEmptyBytes(body) {
-		log.Warn().Str("uri", uri).Msg("file appears to be empty, skipping")
+	if hash := c.Format.ObjectHash(typ, content); hash != obj {
+		log.Warn().Str("uri", uri).Str("hash", hash).Msg("object hash does not match, skipping")
 		return
 	}
 	if err := utils.CreateParentFolders(fullPath); err != nil {
@@ -99,18 +128,5 @@ func FindObjectsWorker(jt *jobtracker.JobTracker, obj string, context jobtracker
 
 	log.Info().Str("obj", obj).Msg("fetched object")
 
-	encObj, err := c.Storage.EncodedObject(plumbing.AnyObject, plumbing.NewHash(obj))
-	if err != nil {
-		log.Error().Str("obj", obj).Err(err).Msg("couldn't read object")
-		return
-	}
-	decObj, err := object.DecodeObject(c.Storage, encObj)
-	if err != nil {
-		log.Error().Str("obj", obj).Err(err).Msg("couldn't decode object")
-		return
-	}
-	referencedHashes := utils.GetReferencedHashes(decObj)
-	for _, h := range referencedHashes {
-		jt.AddJob(h)
-	}
+	jt.AddJobs(c.Grafts.ReferencedHashes(c.Format, obj, typ, content)...)
 }
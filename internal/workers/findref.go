@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 	"sync"
 
+	"github.com/deletescape/goop/internal/gitfmt"
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/jobtracker"
 	"github.com/phuslu/log"
@@ -16,29 +18,69 @@ import (
 )
 
 var refRegex = regexp.MustCompile(`(?m)(refs(/[a-zA-Z0-9\-\.\_\*]+)+)`)
-var branchRegex = regexp.MustCompile(`(?m)branch ["'](.+)["']`)
 
 var checkedRefs = make(map[string]bool)
 var checkedRefsMutex sync.Mutex
 
+// BaseUrl and BaseDir point at the git directory itself rather than the worktree
 type FindRefContext struct {
 	C       *fasthttp.Client
 	BaseUrl string
 	BaseDir string
+	// Branches and Tags are guessed under refs/heads, refs/tags and refs/remotes/<remote>
+	// for every one of Remotes and every remote found in the config
+	Branches []string
+	Tags     []string
+	Remotes  []string
+}
+
+// WordlistRefs returns the refs and reflogs guessed from the wordlists of c.
+func (c FindRefContext) WordlistRefs() []string {
+	var refs []string
+	for _, branch := range c.Branches {
+		refs = append(refs, "refs/heads/"+branch, "logs/refs/heads/"+branch)
+	}
+	for _, tag := range c.Tags {
+		refs = append(refs, "refs/tags/"+tag, "logs/refs/tags/"+tag)
+	}
+	for _, remote := range c.Remotes {
+		refs = append(refs, c.remoteRefs(remote)...)
+	}
+	return refs
+}
+
+func (c FindRefContext) remoteRefs(remote string) []string {
+	refs := []string{
+		fmt.Sprintf("refs/remotes/%s/HEAD", remote),
+		fmt.Sprintf("logs/refs/remotes/%s/HEAD", remote),
+	}
+	for _, branch := range c.Branches {
+		refs = append(refs,
+			fmt.Sprintf("refs/remotes/%s/%s", remote, branch),
+			fmt.Sprintf("logs/refs/remotes/%s/%s", remote, branch),
+		)
+	}
+	return refs
 }
 
 func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Context) {
 	c := context.(FindRefContext)
+	// workers racing the end of the queue receive empty jobs, and names taken
+	// from fetched files must not lead out of the git directory
+	if !isCleanPath(path) {
+		return
+	}
 
 	checkRatelimted()
 
+	uri := utils.Url(c.BaseUrl, path)
 	checkedRefsMutex.Lock()
-	if checked, ok := checkedRefs[path]; checked && ok {
+	if checked, ok := checkedRefs[uri]; checked && ok {
 		// Ref has already been checked
 		checkedRefsMutex.Unlock()
 		return
 	} else {
-		checkedRefs[path] = true
+		checkedRefs[uri] = true
 	}
 	checkedRefsMutex.Unlock()
 
@@ -50,38 +92,10 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 			log.Error().Str("file", targetFile).Err(err).Msg("error while reading file")
 			return
 		}
-		for _, ref := range refRegex.FindAll(content, -1) {
-			jt.AddJob(utils.Url(".git", string(ref)))
-			jt.AddJob(utils.Url(".git/logs", string(ref)))
-		}
-		if path == ".git/FETCH_HEAD" {
-			// TODO figure out actual remote instead of just assuming origin here (if possible)
-			for _, branch := range branchRegex.FindAllSubmatch(content, -1) {
-				jt.AddJob(fmt.Sprintf(".git/refs/remotes/origin/%s", branch[1]))
-				jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/origin/%s", branch[1]))
-			}
-		}
-		if path == ".git/config" || path == ".git/config.worktree" {
-			cfg, err := ini.Load(content)
-			if err != nil {
-				log.Error().Str("file", targetFile).Err(err).Msg("failed to parse git config")
-				return
-			}
-			for _, sec := range cfg.Sections() {
-				if strings.HasPrefix(sec.Name(), "branch ") {
-					parts := strings.SplitN(sec.Name(), " ", 2)
-					branch := strings.Trim(parts[1], `"`)
-					remote := sec.Key("remote").String()
-
-					jt.AddJob(fmt.Sprintf(".git/refs/remotes/%s/%s", remote, branch))
-					jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/%s/%s", remote, branch))
-				}
-			}
-		}
+		findRefs(jt, c, path, targetFile, content)
 		return
 	}
 
-	uri := utils.Url(c.BaseUrl, path)
 	code, body, err := c.C.Get(nil, uri)
 	if err == nil && code != 200 {
 		if code == 429 {
@@ -115,32 +129,174 @@ func FindRefWorker(jt *jobtracker.JobTracker, path string, context jobtracker.Co
 
 	log.Info().Str("uri", uri).Msg("fetched ref")
 
-	for _, ref := range refRegex.FindAll(body, -1) {
-		jt.AddJob(utils.Url(".git", string(ref)))
-		jt.AddJob(utils.Url(".git/logs", string(ref)))
+	findRefs(jt, c, path, targetFile, body)
+}
+
+// findRefs queues the refs mentioned by the content of a fetched ref or config file.
+func findRefs(jt *jobtracker.JobTracker, c FindRefContext, path, targetFile string, content []byte) {
+	for _, ref := range refRegex.FindAll(content, -1) {
+		jt.AddJobs(refJobs(string(ref), "logs/"+string(ref))...)
+	}
+	// FETCH_HEAD can only be mapped to remotes using the config, whichever of the
+	// two is fetched last queues the remote tracking branches
+	if path == "FETCH_HEAD" {
+		config, _ := ioutil.ReadFile(utils.Url(c.BaseDir, "config"))
+		jt.AddJobs(fetchHeadRefs(content, config)...)
 	}
-	if path == ".git/FETCH_HEAD" {
-		// TODO figure out actual remote instead of just assuming origin here (if possible)
-		for _, branch := range branchRegex.FindAllSubmatch(body, -1) {
-			jt.AddJob(fmt.Sprintf(".git/refs/remotes/origin/%s", branch[1]))
-			jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/origin/%s", branch[1]))
+	if path == "config" {
+		if fetchHead, err := ioutil.ReadFile(utils.Url(c.BaseDir, "FETCH_HEAD")); err == nil {
+			jt.AddJobs(fetchHeadRefs(fetchHead, content)...)
 		}
 	}
-	if path == ".git/config" || path == ".git/config.worktree" {
-		cfg, err := ini.Load(body)
-		if err != nil {
-			log.Error().Str("file", targetFile).Err(err).Msg("failed to parse git config")
-			return
+	if isConfigFile(c, path) {
+		findConfigRefs(jt, c, path, targetFile, content)
+	}
+}
+
+// findConfigRefs queues the remote tracking branches described by a config file,
+// as well as the files it includes.
+func findConfigRefs(jt *jobtracker.JobTracker, c FindRefContext, file, targetFile string, content []byte) {
+	cfg, err := ini.Load(content)
+	if err != nil {
+		log.Error().Str("file", targetFile).Err(err).Msg("failed to parse git config")
+		return
+	}
+	remotes, _ := gitfmt.ParseRemotes(content)
+	fetchSpecs := func(remote string) []string {
+		for _, r := range remotes {
+			if r.Name == remote && len(r.Fetch) > 0 {
+				return r.Fetch
+			}
 		}
-		for _, sec := range cfg.Sections() {
-			if strings.HasPrefix(sec.Name(), "branch ") {
-				parts := strings.SplitN(sec.Name(), " ", 2)
-				branch := strings.Trim(parts[1], `"`)
-				remote := sec.Key("remote").String()
-
-				jt.AddJob(fmt.Sprintf(".git/refs/remotes/%s/%s", remote, branch))
-				jt.AddJob(fmt.Sprintf(".git/logs/refs/remotes/%s/%s", remote, branch))
+		return []string{fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", remote)}
+	}
+
+	for _, sec := range cfg.Sections() {
+		if strings.HasPrefix(sec.Name(), "branch ") {
+			parts := strings.SplitN(sec.Name(), " ", 2)
+			branch := strings.Trim(parts[1], `"`)
+			remote := sec.Key("remote").String()
+
+			jt.AddJobs(refJobs(
+				fmt.Sprintf("refs/remotes/%s/%s", remote, branch),
+				fmt.Sprintf("logs/refs/remotes/%s/%s", remote, branch),
+			)...)
+			// the upstream branch doesn't have to share the local branch's name
+			if merge := sec.Key("merge").String(); merge != "" {
+				for _, spec := range fetchSpecs(remote) {
+					if ref, ok := gitfmt.MapRefspec(spec, merge); ok {
+						jt.AddJobs(refJobs(ref, "logs/"+ref)...)
+					}
+				}
 			}
 		}
 	}
+
+	for _, remote := range remotes {
+		jt.AddJobs(refJobs(c.remoteRefs(remote.Name)...)...)
+		for _, spec := range remote.Fetch {
+			jt.AddJobs(refJobs(c.refspecRefs(spec)...)...)
+		}
+	}
+
+	for _, include := range gitfmt.ConfigIncludes(content) {
+		// only includes within the git directory can be fetched
+		if strings.HasPrefix(include, "/") || strings.HasPrefix(include, "~") {
+			continue
+		}
+		include = path.Join(path.Dir(file), include)
+		if include == ".." || strings.HasPrefix(include, "../") {
+			continue
+		}
+		configFiles.Store(utils.Url(c.BaseUrl, include), true)
+		jt.AddJob(include)
+	}
+}
+
+// refspecRefs returns the refs the branches and tags from the wordlists are
+// stored as when fetched using a refspec.
+func (c FindRefContext) refspecRefs(spec string) []string {
+	var refs []string
+	add := func(src string) {
+		if ref, ok := gitfmt.MapRefspec(spec, src); ok && strings.HasPrefix(ref, "refs/") {
+			refs = append(refs, ref, "logs/"+ref)
+		}
+	}
+	for _, branch := range c.Branches {
+		add("refs/heads/" + branch)
+	}
+	for _, tag := range c.Tags {
+		add("refs/tags/" + tag)
+	}
+	add("HEAD")
+	return refs
+}
+
+// configFiles holds the urls of included config files
+var configFiles sync.Map
+
+func isConfigFile(c FindRefContext, path string) bool {
+	if path == "config" || path == "config.worktree" {
+		return true
+	}
+	_, ok := configFiles.Load(utils.Url(c.BaseUrl, path))
+	return ok
+}
+
+// fetchHeadRefs returns the refs the entries of FETCH_HEAD were stored as, using
+// the remote urls in config to find the remote they were fetched from. Entries
+// fetched from an unknown url are assumed to come from origin.
+func fetchHeadRefs(fetchHead, config []byte) []string {
+	remotes, _ := gitfmt.ParseRemotes(config)
+	var refs []string
+	for _, entry := range gitfmt.ParseFetchHead(fetchHead) {
+		var names []string
+		for _, remote := range remotes {
+			for _, u := range remote.Urls {
+				if gitfmt.SameUrl(u, entry.Url) || remote.Name == entry.Url {
+					names = append(names, remote.Name)
+					break
+				}
+			}
+		}
+		if len(names) == 0 {
+			names = []string{"origin"}
+		}
+
+		switch {
+		case entry.Kind == "tag":
+			refs = append(refs, "refs/tags/"+entry.Name, "logs/refs/tags/"+entry.Name)
+		case strings.HasPrefix(entry.Name, "refs/"):
+			refs = append(refs, entry.Name, "logs/"+entry.Name)
+		default:
+			branch := entry.Name
+			if branch == "" {
+				branch = "HEAD"
+			}
+			for _, remote := range names {
+				refs = append(refs,
+					fmt.Sprintf("refs/remotes/%s/%s", remote, branch),
+					fmt.Sprintf("logs/refs/remotes/%s/%s", remote, branch),
+				)
+			}
+		}
+	}
+	return refJobs(refs...)
+}
+
+func isCleanPath(p string) bool {
+	return p != "" && path.Clean(p) == p && !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// refJobs drops the refs and reflogs whose name isn't a valid ref. Names
+// taken from fetched files are turned into paths in the git directory, so
+// they must not be able to point anywhere else.
+func refJobs(jobs ...string) []string {
+	var valid []string
+	for _, job := range jobs {
+		if gitfmt.IsRefName(strings.TrimPrefix(job, "logs/")) {
+			valid = append(valid, job)
+		}
+	}
+	return valid
 }
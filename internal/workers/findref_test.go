@@ -0,0 +1,51 @@
+package workers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRefJobs(t *testing.T) {
+	tests := []struct {
+		name string
+		jobs []string
+		want []string
+	}{
+		{"ref and reflog", []string{"refs/heads/main", "logs/refs/heads/main"}, []string{"refs/heads/main", "logs/refs/heads/main"}},
+		{"parent directory", []string{"refs/heads/../../config", "logs/refs/heads/../../config"}, nil},
+		{"current directory", []string{"refs/remotes/./origin/main"}, nil},
+		{"glob from a refspec", []string{"refs/remotes/origin/*"}, nil},
+		{"empty", []string{"", "logs/"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refJobs(tt.jobs...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCleanPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"config", true},
+		{"refs/heads/main", true},
+		{"", false},
+		{"..", false},
+		{"../config", false},
+		{"refs/heads/../../../escape", false},
+		{"refs/./heads", false},
+		{"/etc/passwd", false},
+		{"refs//heads", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := isCleanPath(tt.path); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package goop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// git itself doesn't follow alternates any deeper than this either
+const maxAlternateDepth = 5
+
+// findAlternates resolves objects/info/alternates and objects/info/http-alternates
+// to the urls of the object stores they point at, following the alternates of
+// those stores as well.
+func findAlternates(gitDir, gitUrl string) []string {
+	objectsUrl := utils.Url(gitUrl, "objects")
+	var alternates []string
+	seen := map[string]bool{objectsUrl: true}
+
+	var follow func(string, []byte, []byte, int)
+	follow = func(objUrl string, alts, httpAlts []byte, depth int) {
+		candidates := resolveAlternates(objUrl, alts, false)
+		candidates = append(candidates, resolveAlternates(objUrl, httpAlts, true)...)
+		for _, alt := range candidates {
+			if seen[alt] {
+				continue
+			}
+			seen[alt] = true
+			log.Info().Str("base", gitUrl).Str("alternate", alt).Msg("found alternate object store")
+			alternates = append(alternates, alt)
+			if depth < maxAlternateDepth {
+				follow(alt, fetchText(utils.Url(alt, "info/alternates")), fetchText(utils.Url(alt, "info/http-alternates")), depth+1)
+			}
+		}
+	}
+
+	readLocal := func(file string) []byte {
+		content, _ := ioutil.ReadFile(utils.Url(gitDir, file))
+		return content
+	}
+	follow(objectsUrl, readLocal("objects/info/alternates"), readLocal("objects/info/http-alternates"), 1)
+	return alternates
+}
+
+// resolveAlternates turns the lines of an alternates file into object store urls.
+// Relative paths are relative to the objects directory containing the file, while
+// absolute paths are filesystem paths on the server, which we can only guess the
+// url for.
+func resolveAlternates(objUrl string, content []byte, isHttp bool) []string {
+	base, err := url.Parse(objUrl + "/")
+	if err != nil {
+		return nil
+	}
+	var resolved []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isHttp && (strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://")) {
+			resolved = append(resolved, strings.TrimSuffix(line, "/"))
+			continue
+		}
+		if strings.HasPrefix(line, "/") && !isHttp {
+			resolved = append(resolved, guessAbsoluteAlternate(base, line)...)
+			continue
+		}
+		ref, err := url.Parse(line)
+		if err != nil {
+			continue
+		}
+		resolved = append(resolved, strings.TrimSuffix(base.ResolveReference(ref).String(), "/"))
+	}
+	return resolved
+}
+
+// guessAbsoluteUrls maps a filesystem path on the server to urls by stripping
+// leading path components until probe accepts the result, as the document root
+// isn't known to us.
+func guessAbsoluteUrls(base *url.URL, p string, probe func(string) bool) []string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	var found []string
+	for i := range parts {
+		u := *base
+		u.Path = "/" + strings.Join(parts[i:], "/")
+		candidate := strings.TrimSuffix(u.String(), "/")
+		if probe(candidate) {
+			found = append(found, candidate)
+		}
+	}
+	return found
+}
+
+func guessAbsoluteAlternate(base *url.URL, p string) []string {
+	found := guessAbsoluteUrls(base, p, func(candidate string) bool {
+		code, body, err := c.Get(nil, utils.Url(candidate, "info/packs"))
+		return err == nil && code == 200 && !utils.IsHtml(body)
+	})
+	if len(found) == 0 {
+		// fall back to assuming the document root is the filesystem root
+		u := *base
+		u.Path = p
+		found = append(found, strings.TrimSuffix(u.String(), "/"))
+	}
+	return found
+}
+
+func fetchText(u string) []byte {
+	code, body, err := c.Get(nil, u)
+	if err != nil || code != 200 || utils.IsHtml(body) {
+		return nil
+	}
+	return body
+}
+
+// fetchAlternatePacks downloads the packs listed by each alternate object store
+// into our own pack directory.
+func fetchAlternatePacks(gitDir string, alternates []string, report *Report) {
+	objectsDir := utils.Url(gitDir, "objects")
+	for _, alt := range alternates {
+		infoPacks := fetchText(utils.Url(alt, "info/packs"))
+		var packs []string
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, hash := range packRegex.FindAllSubmatch(infoPacks, -1) {
+			pack := fmt.Sprintf("pack-%s", hash[1])
+			packs = append(packs, pack)
+			jt.AddJobs(
+				fmt.Sprintf("pack/%s.idx", pack),
+				fmt.Sprintf("pack/%s.pack", pack),
+			)
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: alt, BaseDir: objectsDir}, false)
+		for _, pack := range packs {
+			if utils.Exists(utils.Url(objectsDir, fmt.Sprintf("pack/%s.pack", pack))) {
+				rep := report.alternate(alt)
+				rep.Packs = append(rep.Packs, pack)
+			}
+		}
+	}
+}
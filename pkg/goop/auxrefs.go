@@ -0,0 +1,117 @@
+package goop
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+var (
+	// bisectFiles describe an ongoing bisect, BISECT_HEAD is a ref
+	bisectFiles = []string{
+		"BISECT_LOG",
+		"BISECT_START",
+		"BISECT_TERMS",
+		"BISECT_NAMES",
+		"BISECT_EXPECTED_REV",
+		"BISECT_ANCESTORS_OK",
+		"BISECT_RUN",
+		"BISECT_HEAD",
+	}
+	notesRefs = []string{
+		"refs/notes/commits",
+		"refs/notes/review",
+		"refs/notes/amlog",
+	}
+
+	branchRefRegex = regexp.MustCompile(`(?m)(?:^|\s)(refs/(?:heads|tags|remotes)/[^\s^]+)`)
+	bisectLogRegex = regexp.MustCompile(`(?m)^git bisect (\S+) ([a-f0-9]{64}|[a-f0-9]{40})`)
+	originalRegex  = regexp.MustCompile(`(?m)(?:^|\s)(refs/original/[^\s^]+)`)
+	replaceRegex   = regexp.MustCompile(`(?m)(?:^|\s)(refs/replace/(?:[a-f0-9]{64}|[a-f0-9]{40}))(?:\s|$)`)
+)
+
+// fetchBisectState fetches the files left behind by an ongoing bisect.
+func fetchBisectState(gitDir, gitUrl string) {
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(bisectFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir, AlllowEmpty: true}, false)
+}
+
+// auxRefProber looks for notes, replace refs, bisect refs, the backups
+// filter-branch keeps in refs/original and Magit's wip refs, guessing names
+// from the refs discovered so far. Refs are only probed once across calls.
+type auxRefProber struct {
+	probed map[string]bool
+}
+
+func newAuxRefProber() *auxRefProber {
+	return &auxRefProber{probed: make(map[string]bool)}
+}
+
+func (p *auxRefProber) probe(gitDir, gitUrl string) {
+	jobs := append([]string{}, notesRefs...)
+	seen := seenRefs(gitDir)
+
+	for _, match := range branchRefRegex.FindAllStringSubmatch(seen, -1) {
+		ref := match[1]
+		jobs = append(jobs, "refs/original/"+ref)
+		if strings.HasPrefix(ref, "refs/heads/") {
+			jobs = append(jobs, "refs/wip/wtree/"+ref, "refs/wip/index/"+ref)
+		}
+	}
+
+	bad, good := "bad", "good"
+	if terms := readLines(gitDir, "BISECT_TERMS"); len(terms) == 2 {
+		bad, good = terms[0], terms[1]
+	}
+	jobs = append(jobs, "refs/bisect/"+bad)
+	for _, match := range bisectLogRegex.FindAllStringSubmatch(readString(gitDir, "BISECT_LOG"), -1) {
+		switch match[1] {
+		case good, "skip":
+			jobs = append(jobs, "refs/bisect/"+match[1]+"-"+match[2])
+		}
+	}
+
+	// replace refs are named after the object they replace, so they can't be
+	// guessed, only picked up from packed-refs, info/refs and the refs
+	// mentioned by files fetched so far
+	for _, match := range replaceRegex.FindAllStringSubmatch(seen, -1) {
+		jobs = append(jobs, match[1])
+	}
+
+	var refs []string
+	for _, ref := range jobs {
+		if !p.probed[ref] {
+			p.probed[ref] = true
+			refs = append(refs, ref, "logs/"+ref)
+		}
+	}
+	if len(refs) == 0 {
+		return
+	}
+	jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(refs...)
+	jt.StartAndWait(findRefContext(gitUrl, gitDir), true)
+}
+
+// originalRefs returns the refs filter-branch kept from before history was
+// rewritten, which often still contain what the rewrite was meant to remove.
+func originalRefs(gitDir string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, match := range originalRegex.FindAllStringSubmatch(seenRefs(gitDir), -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			refs = append(refs, match[1])
+		}
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		log.Warn().Str("dir", gitDir).Str("ref", ref).Msg("found ref kept from before history was rewritten")
+	}
+	return refs
+}
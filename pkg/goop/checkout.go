@@ -0,0 +1,208 @@
+package goop
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/phuslu/log"
+)
+
+// CheckoutPlaceholders makes the checkout write a placeholder in place of files
+// whose blobs couldn't be recovered instead of skipping them.
+var CheckoutPlaceholders = false
+
+var errNothingToCheckout = errors.New("neither an index nor HEAD's tree to check out")
+
+// checkout writes the files staged in the index, or those of HEAD's tree if
+// there is no index, to the worktree like `git checkout .` does, without
+// depending on a git binary or its configuration. Unlike git it carries on
+// when blobs are missing, leaving files already fetched from the server in
+// place, and returns the paths that couldn't be recovered. The binary is only
+// used if the in-process checkout can't be done at all.
+func checkout(baseDir, gitDir, gitUrl string, format gitfmt.ObjectFormat) ([]string, error) {
+	log.Info().Str("dir", baseDir).Msg("checking out files from the index")
+	missing, err := checkoutIndex(baseDir, gitDir, gitUrl, format)
+	if err == nil {
+		if len(missing) > 0 {
+			log.Warn().Str("dir", baseDir).Int("count", len(missing)).Msg("some files couldn't be recovered")
+		}
+		return missing, nil
+	}
+	log.Warn().Str("dir", baseDir).Err(err).Msg("couldn't check out in-process, running git checkout .")
+	cmd := exec.Command("git", "checkout", ".")
+	cmd.Dir = baseDir
+	return nil, cmd.Run()
+}
+
+func checkoutIndex(baseDir, gitDir, gitUrl string, format gitfmt.ObjectFormat) ([]string, error) {
+	fs := osfs.New(baseDir)
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	entries, err := checkoutEntries(gitDir, gitUrl, format, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, entry := range entries {
+		// unmerged entries are left alone just like git does
+		if entry.Stage != 0 || entry.SkipWorktree || entry.IntentToAdd {
+			continue
+		}
+		if !isSafeWorktreePath(entry.Name) {
+			log.Warn().Str("dir", baseDir).Str("path", entry.Name).Msg("skipping unsafe index entry")
+			continue
+		}
+		// symlinks are checked out too, so they could lead later entries out of the worktree
+		if hasSymlinkParent(baseDir, entry.Name) {
+			log.Warn().Str("dir", baseDir).Str("path", entry.Name).Msg("skipping index entry beyond a symlink")
+			continue
+		}
+		if filemode.FileMode(entry.Mode) != filemode.Submodule && !store.HasObject(entry.Hash) {
+			// fetchMissing may have gotten the file from the server already,
+			// anything else found in its place is kept but isn't the staged file
+			if matchesEntry(baseDir, entry, format) {
+				continue
+			}
+			missing = append(missing, entry.Name)
+			if _, err := fs.Lstat(entry.Name); err != nil && CheckoutPlaceholders {
+				if err := writePlaceholder(fs, entry); err != nil {
+					log.Warn().Str("dir", baseDir).Str("path", entry.Name).Err(err).Msg("couldn't write placeholder")
+				}
+			}
+			continue
+		}
+		if err := checkoutEntry(fs, store, entry); err != nil {
+			log.Warn().Str("dir", baseDir).Str("path", entry.Name).Err(err).Msg("couldn't check out file")
+			missing = append(missing, entry.Name)
+		}
+	}
+	return missing, nil
+}
+
+// checkoutEntries returns the entries of the index, falling back to the files
+// of HEAD's tree when there is no index.
+func checkoutEntries(gitDir, gitUrl string, format gitfmt.ObjectFormat, store *gitfmt.Store) ([]*gitfmt.IndexEntry, error) {
+	if idx := readIndex(gitDir, gitUrl, format); idx != nil {
+		return idx.Entries, nil
+	}
+	head, headCommit := readHead(gitDir, format, store)
+	if headCommit == nil {
+		return nil, errNothingToCheckout
+	}
+	log.Info().Str("dir", gitDir).Str("commit", head).Msg("no index, checking out the tree of HEAD")
+
+	var entries []*gitfmt.IndexEntry
+	var walk func(hash, prefix string)
+	walk = func(hash, prefix string) {
+		tree, err := store.ReadTree(hash)
+		if err != nil {
+			log.Warn().Str("dir", gitDir).Str("path", prefix).Str("tree", hash).Msg("couldn't read tree, skipping the files below it")
+			return
+		}
+		for _, e := range tree {
+			if filemode.FileMode(e.Mode) == filemode.Dir {
+				walk(e.Hash, prefix+e.Name+"/")
+				continue
+			}
+			entries = append(entries, &gitfmt.IndexEntry{Name: prefix + e.Name, Hash: e.Hash, Mode: e.Mode})
+		}
+	}
+	walk(headCommit.Tree, "")
+	return entries, nil
+}
+
+// matchesEntry reports whether the file at the entry's path in dir has the
+// contents of the entry's blob.
+func matchesEntry(dir string, entry *gitfmt.IndexEntry, format gitfmt.ObjectFormat) bool {
+	fp := filepath.Join(dir, entry.Name)
+	fi, err := os.Lstat(fp)
+	if err != nil {
+		return false
+	}
+	var content []byte
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(fp)
+		if err != nil {
+			return false
+		}
+		content = []byte(target)
+	} else if content, err = ioutil.ReadFile(fp); err != nil {
+		return false
+	}
+	return format.ObjectHash(gitfmt.BlobObject, content) == entry.Hash
+}
+
+// hasSymlinkParent reports whether any of the directories leading to p inside
+// dir is a symlink.
+func hasSymlinkParent(dir, p string) bool {
+	for i, r := range p {
+		if r != '/' {
+			continue
+		}
+		if fi, err := os.Lstat(filepath.Join(dir, p[:i])); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func checkoutEntry(fs billy.Filesystem, store *gitfmt.Store, entry *gitfmt.IndexEntry) error {
+	mode := filemode.FileMode(entry.Mode)
+	if mode == filemode.Submodule {
+		return fs.MkdirAll(entry.Name, os.ModePerm)
+	}
+	typ, content, err := store.ReadObject(entry.Hash)
+	if err != nil {
+		return err
+	}
+	if typ != gitfmt.BlobObject {
+		return fmt.Errorf("%s is a %s, not a blob", entry.Hash, typ)
+	}
+
+	// whatever is in the way is replaced, like git does
+	fs.Remove(entry.Name)
+	if mode == filemode.Symlink {
+		if err := fs.Symlink(string(content), entry.Name); err == nil {
+			return nil
+		}
+		// where symlinks aren't supported git writes the target as a file
+	}
+	osMode, err := mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(entry.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, osMode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writePlaceholder marks a file whose blob couldn't be recovered.
+func writePlaceholder(fs billy.Filesystem, entry *gitfmt.IndexEntry) error {
+	f, err := fs.OpenFile(entry.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "goop: blob %s of this file couldn't be recovered\n", entry.Hash); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,172 @@
+package goop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+)
+
+// clearWorktree removes everything but the git directory from dir.
+func clearWorktree(t *testing.T, dir string) {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.Name() != ".git" {
+			if err := os.RemoveAll(filepath.Join(dir, f.Name())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestCheckoutIndex(t *testing.T) {
+	const commit = `
+echo a > a.txt
+mkdir dir
+echo b > dir/b.txt
+printf '#!/bin/sh\n' > run.sh
+chmod +x run.sh
+ln -s dir/b.txt link
+git add .
+git commit -qm init
+`
+	tests := []struct {
+		name   string
+		script string
+		format gitfmt.ObjectFormat
+		// contents of a.txt
+		want string
+	}{
+		{"index", "git init -q" + commit, gitfmt.SHA1, "a\n"},
+		{"sha256 index", "git init -q --object-format=sha256" + commit, gitfmt.SHA256, "a\n"},
+		{"staged changes", "git init -q" + commit + "echo staged > a.txt\ngit add a.txt\n", gitfmt.SHA1, "staged\n"},
+		{"no index", "git init -q" + commit + "echo staged > a.txt\ngit add a.txt\nrm .git/index\n", gitfmt.SHA1, "a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := gitRepo(t, tt.script)
+			clearWorktree(t, dir)
+			missing, err := checkoutIndex(dir, filepath.Join(dir, ".git"), "", tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(missing) > 0 {
+				t.Errorf("got missing files %v", missing)
+			}
+			if got := readFile(t, filepath.Join(dir, "a.txt")); got != tt.want {
+				t.Errorf("a.txt: got %q, want %q", got, tt.want)
+			}
+			if got := readFile(t, filepath.Join(dir, "dir/b.txt")); got != "b\n" {
+				t.Errorf("dir/b.txt: got %q", got)
+			}
+			if fi, err := os.Stat(filepath.Join(dir, "run.sh")); err != nil || fi.Mode()&0100 == 0 {
+				t.Errorf("run.sh isn't executable: %v", err)
+			}
+			if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "dir/b.txt" {
+				t.Errorf("link: got %q, %v", target, err)
+			}
+		})
+	}
+}
+
+func TestCheckoutNothing(t *testing.T) {
+	dir := gitRepo(t, "git init -q")
+	if _, err := checkoutIndex(dir, filepath.Join(dir, ".git"), "", gitfmt.SHA1); err != errNothingToCheckout {
+		t.Errorf("got %v, want %v", err, errNothingToCheckout)
+	}
+}
+
+func TestHasSymlinkParent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "d/e"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"l": "d", "d/l": "e", "outside": os.TempDir()} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"d/e/x", false},
+		{"missing/x", false},
+		// only the directories leading to the path count
+		{"l", false},
+		{"l/x", true},
+		{"d/l/x", true},
+		{"outside/x", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := hasSymlinkParent(dir, tt.path); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckoutMissingBlobs(t *testing.T) {
+	tests := []struct {
+		name         string
+		placeholders bool
+		// contents of b.txt before the checkout, if any
+		existing string
+		missing  bool
+		// contents of b.txt after the checkout, if any
+		want string
+	}{
+		{name: "nothing in place", missing: true},
+		{name: "fetched from the server", existing: "b\n", want: "b\n"},
+		{name: "other file in place", existing: "other\n", missing: true, want: "other\n"},
+		{name: "placeholder", placeholders: true, missing: true, want: "goop: blob "},
+		{name: "other file kept over placeholder", placeholders: true, existing: "other\n", missing: true, want: "other\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := gitRepo(t, `
+git init -q
+echo a > a.txt
+echo b > b.txt
+git add .
+git commit -qm init
+rm .git/objects/$(git rev-parse :b.txt | cut -c1-2)/$(git rev-parse :b.txt | cut -c3-)
+`)
+			clearWorktree(t, dir)
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer func(placeholders bool) { CheckoutPlaceholders = placeholders }(CheckoutPlaceholders)
+			CheckoutPlaceholders = tt.placeholders
+
+			missing, err := checkoutIndex(dir, filepath.Join(dir, ".git"), "", gitfmt.SHA1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(missing) == 1 && missing[0] == "b.txt"; got != tt.missing || !tt.missing && len(missing) > 0 {
+				t.Errorf("got missing files %v", missing)
+			}
+			if got := readFile(t, filepath.Join(dir, "a.txt")); got != "a\n" {
+				t.Errorf("a.txt: got %q", got)
+			}
+			content, err := ioutil.ReadFile(filepath.Join(dir, "b.txt"))
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("b.txt: got %q, want no file", content)
+				}
+			} else if !strings.HasPrefix(string(content), tt.want) {
+				t.Errorf("b.txt: got %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
@@ -8,22 +8,16 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/deletescape/goop/internal/gitfmt"
 	"github.com/deletescape/goop/internal/utils"
 	"github.com/deletescape/goop/internal/workers"
 	"github.com/deletescape/jobtracker"
-	"github.com/go-git/go-billy/v5/osfs"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/cache"
-	"github.com/go-git/go-git/v5/plumbing/format/commitgraph"
-	"github.com/go-git/go-git/v5/plumbing/format/index"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/filesystem"
-	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/phuslu/log"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpproxy"
@@ -143,8 +137,23 @@ func Clone(u, dir string, force, keep bool) error {
 }
 
 func FetchGit(baseUrl, baseDir string) error {
+	gitUrl := utils.Url(baseUrl, ".git")
+	gitDir := utils.Url(baseDir, ".git")
+	if !isGitHead(utils.Url(gitUrl, "HEAD")) {
+		// linked worktrees and absorbed submodules only have a file pointing at their git directory
+		if linked := resolveGitFile(baseUrl); linked != "" {
+			gitUrl = fetchLinkedWorktree(linked, gitDir)
+		}
+	}
+	return fetchGit(baseUrl, baseDir, gitUrl, gitDir, 0)
+}
+
+// fetchGit dumps the repository with its git directory at gitUrl into gitDir,
+// and its worktree at baseUrl into baseDir. depth is the number of submodules
+// the repository is nested in.
+func fetchGit(baseUrl, baseDir, gitUrl, gitDir string, depth int) error {
 	log.Info().Str("base", baseUrl).Msg("testing for .git/HEAD")
-	code, body, err := c.Get(nil, utils.Url(baseUrl, ".git/HEAD"))
+	code, body, err := c.Get(nil, utils.Url(gitUrl, "HEAD"))
 	if err != nil {
 		return err
 	}
@@ -156,7 +165,7 @@ func FetchGit(baseUrl, baseDir string) error {
 	}
 
 	log.Info().Str("base", baseUrl).Msg("testing if recursive download is possible")
-	code, body, err = c.Get(body, utils.Url(baseUrl, ".git/"))
+	code, body, err = c.Get(body, gitUrl+"/")
 	if err != nil {
 		if utils.IgnoreError(err) {
 			log.Error().Str("base", baseUrl).Int("code", code).Err(err)
@@ -166,7 +175,7 @@ func FetchGit(baseUrl, baseDir string) error {
 	}
 
 	if code == 200 && utils.IsHtml(body) {
-		lnk, _ := url.Parse(utils.Url(baseUrl, ".git/"))
+		lnk, _ := url.Parse(gitUrl + "/")
 		indexedFiles, err := utils.GetIndexedFiles(body, lnk.Path)
 		if err != nil {
 			return err
@@ -175,12 +184,16 @@ func FetchGit(baseUrl, baseDir string) error {
 			log.Info().Str("base", baseUrl).Msg("fetching .git/ recursively")
 			jt := jobtracker.NewJobTracker(workers.RecursiveDownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
 			jt.AddJobs(indexedFiles...)
-			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseUrl: utils.Url(baseUrl, ".git/"), BaseDir: utils.Url(baseDir, ".git/")}, true)
+			jt.StartAndWait(workers.RecursiveDownloadContext{C: c, BaseUrl: gitUrl + "/", BaseDir: gitDir + "/"}, true)
 
-			if err := checkout(baseDir); err != nil {
+			var untracked []string
+			if idx := readIndex(gitDir, gitUrl, readObjectFormat(gitDir)); idx != nil {
+				untracked = indexUntracked(gitDir, idx)
+			}
+			if _, err := checkout(baseDir, gitDir, gitUrl, readObjectFormat(gitDir)); err != nil {
 				log.Error().Str("dir", baseDir).Err(err).Msg("failed to checkout")
 			}
-			if err := fetchIgnored(baseDir, baseUrl); err != nil {
+			if err := fetchIgnored(baseDir, baseUrl, untracked); err != nil {
 				return err
 			}
 		}
@@ -189,53 +202,257 @@ func FetchGit(baseUrl, baseDir string) error {
 	log.Info().Str("base", baseUrl).Msg("fetching common files")
 	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
 	jt.AddJobs(commonFiles...)
+	jt.AddJobs(wordlists.Files...)
 	jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: baseDir, BaseUrl: baseUrl}, false)
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(commonGitFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: gitDir, BaseUrl: gitUrl}, false)
 
 	log.Info().Str("base", baseUrl).Msg("finding refs")
 	jt = jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	refCtx := findRefContext(gitUrl, gitDir)
 	jt.AddJobs(commonRefs...)
-	jt.StartAndWait(workers.FindRefContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir}, true)
+	jt.AddJobs(refCtx.WordlistRefs()...)
+	jt.StartAndWait(refCtx, true)
+
+	report := &Report{Remotes: readRemotes(gitDir)}
+	probeForgeRefs(gitDir, gitUrl, report)
+	fetchOperationState(gitDir, gitUrl, report)
+	fetchBisectState(gitDir, gitUrl)
+	auxRefs := newAuxRefProber()
+	auxRefs.probe(gitDir, gitUrl)
+	tags := newTagProber()
+	tags.probe(gitDir, gitUrl)
+
+	format := readObjectFormat(gitDir)
+	log.Info().Str("base", baseUrl).Str("format", string(format)).Msg("detected object format")
 
 	log.Info().Str("base", baseUrl).Msg("finding packs")
-	infoPacksPath := utils.Url(baseDir, ".git/objects/info/packs")
+	var packs []string
+	infoPacksPath := utils.Url(gitDir, "objects/info/packs")
 	if utils.Exists(infoPacksPath) {
 		infoPacks, err := ioutil.ReadFile(infoPacksPath)
 		if err != nil {
 			return err
 		}
-		hashes := packRegex.FindAllSubmatch(infoPacks, -1)
-		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-		for _, sha1 := range hashes {
-			jt.AddJobs(
-				fmt.Sprintf(".git/objects/pack/pack-%s.idx", sha1[1]),
-				fmt.Sprintf(".git/objects/pack/pack-%s.pack", sha1[1]),
-				fmt.Sprintf(".git/objects/pack/pack-%s.rev", sha1[1]),
-			)
+		for _, hash := range packRegex.FindAllSubmatch(infoPacks, -1) {
+			packs = append(packs, fmt.Sprintf("pack-%s", hash[1]))
 		}
-		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir}, false)
 	}
+	midx := readMultiPackIndex(gitDir, format)
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	if midx != nil {
+		packs = append(packs, midx.Packs...)
+		jt.AddJobs(
+			fmt.Sprintf("objects/pack/multi-pack-index-%s.rev", midx.Checksum),
+			fmt.Sprintf("objects/pack/multi-pack-index-%s.bitmap", midx.Checksum),
+		)
+	}
+	for _, pack := range packs {
+		jt.AddJobs(
+			fmt.Sprintf("objects/pack/%s.idx", pack),
+			fmt.Sprintf("objects/pack/%s.pack", pack),
+			fmt.Sprintf("objects/pack/%s.rev", pack),
+			fmt.Sprintf("objects/pack/%s.bitmap", pack),
+		)
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir}, false)
+
+	alternates := findAlternates(gitDir, gitUrl)
+	fetchAlternatePacks(gitDir, alternates, report)
 
 	log.Info().Str("base", baseUrl).Msg("finding objects")
 	objs := make(map[string]bool) // object "set"
-	//var packed_objs [][]byte
+	if midx != nil {
+		for _, hash := range midx.Objects {
+			objs[hash] = true
+		}
+	}
+
+	fetchWorktrees(gitDir, gitUrl, format, report, objs)
+
+	if err := refObjects(gitDir, objs); err != nil {
+		return err
+	}
+
+	// the untracked cache has to be read before checking out, as git drops it when rewriting the index
+	var untracked []string
+	if idx := readIndex(gitDir, gitUrl, format); idx != nil {
+		for _, hash := range indexObjects(gitDir, idx) {
+			objs[hash] = true
+		}
+		untracked = indexUntracked(gitDir, idx)
+	}
+
+	grafts := readGrafts(gitDir, format, report)
+
+	if err := gitfmt.ForEachLooseObject(gitDir, format, func(hash string) error {
+		objs[hash] = true
+		typ, content, err := gitfmt.ReadLooseObject(utils.Url(gitDir, gitfmt.LooseObjectPath(hash)))
+		if err != nil {
+			return err
+		}
+		for _, hash := range grafts.ReferencedHashes(format, hash, typ, content) {
+			objs[hash] = true
+		}
+		return nil
+	}); err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("error while processing object files")
+	}
+
+	// Parse stand alone commit graph file
+	parseGraphFile(gitDir, utils.Url(gitDir, "objects/info/commit-graph"), format, objs)
+
+	// Parse commit graph chains
+	commitGraphList := utils.Url(gitDir, "objects/info/commit-graphs/commit-graph-chain")
+	if utils.Exists(commitGraphList) {
+		var graphFiles []string
+		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		f, err := os.Open(commitGraphList)
+		if err != nil {
+			log.Error().Str("dir", baseDir).Err(err).Msg("failed to open commit graph chain")
+		} else {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if !strings.HasPrefix(line, "#") {
+					graphFile := fmt.Sprintf("objects/info/commit-graphs/graph-%s.graph", line)
+					graphFiles = append(graphFiles, graphFile)
+					jt.AddJob(graphFile)
+				}
+			}
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: gitDir, BaseUrl: gitUrl}, false)
+		for _, graphFile := range graphFiles {
+			parseGraphFile(gitDir, utils.Url(gitDir, graphFile), format, objs)
+		}
+	}
+
+	if looseMap := readLooseObjectMap(gitDir, format); looseMap != nil {
+		for _, hash := range looseMap.Objects {
+			objs[hash] = true
+		}
+		// hashes found in text files might be names in the compatibility object format
+		for hash := range objs {
+			if main, ok := looseMap.Main[hash]; ok {
+				delete(objs, hash)
+				objs[main] = true
+			}
+		}
+	}
+
+	miner := newRefMiner()
+	packed := scanPacks(gitDir, format, grafts, objs, miner)
+	for hash := range packed {
+		delete(objs, hash)
+	}
+
+	log.Info().Str("base", baseUrl).Msg("fetching objects")
+	var sources sync.Map
+	fetchObjects := func(objs map[string]bool) {
+		jt := jobtracker.NewJobTracker(workers.FindObjectsWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for obj := range objs {
+			jt.AddJob(obj)
+		}
+		jt.StartAndWait(workers.FindObjectsContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir, Format: format, Packed: packed, Grafts: grafts, Alternates: alternates, Sources: &sources}, true)
+	}
+	fetchObjects(objs)
+
+	// the names mentioned in commit messages and reflogs lead to more commits
+	// mentioning more names, so keep going until no new names turn up
+	for {
+		miner.mineGitDir(gitDir, format)
+		names := miner.take()
+		if len(names) == 0 {
+			break
+		}
+		log.Info().Str("base", baseUrl).Strs("names", names).Msg("looking for refs mentioned in commits and reflogs")
+		jt = jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, name := range names {
+			jt.AddJobs(refNameJobs(name)...)
+		}
+		jt.StartAndWait(refCtx, true)
+		auxRefs.probe(gitDir, gitUrl)
+		tags.probe(gitDir, gitUrl)
+
+		found := make(map[string]bool)
+		if err := refObjects(gitDir, found); err != nil {
+			log.Error().Str("dir", gitDir).Err(err).Msg("error while processing refs")
+		}
+		fetchObjects(found)
+	}
+
+	recoverStashes(gitDir, format, report)
+	report.OriginalRefs = originalRefs(gitDir)
+
+	sources.Range(func(obj, store interface{}) bool {
+		alt := report.alternate(store.(string))
+		alt.Objects = append(alt.Objects, obj.(string))
+		return true
+	})
+
+	// exit early if we haven't managed to dump anything
+	if !utils.Exists(baseDir) {
+		return nil
+	}
+
+	fetchMissing(baseDir, baseUrl, gitDir, gitUrl, format)
+	rebuildTrees(gitDir, gitUrl, format, report)
+	commitIndex(gitDir, gitUrl, format, report)
+	recoverLostFound(gitDir, format, report)
+
+	missing, err := checkout(baseDir, gitDir, gitUrl, format)
+	if err != nil {
+		log.Error().Str("dir", baseDir).Err(err).Msg("failed to checkout")
+	}
+	report.MissingFiles = missing
+
+	// <fetch lfs objects and manually check them out>
+	fetchLfs(baseDir, gitDir, gitUrl)
+
+	if err := fetchIgnored(baseDir, baseUrl, untracked); err != nil {
+		return err
+	}
+
+	fetchSubmodules(baseUrl, baseDir, gitUrl, gitDir, format, depth, report)
+
+	report.write(gitDir)
+	return nil
+}
 
+// refObjects adds the objects mentioned by refs, reflogs and other ref-like files
+// to objs. Refs that only have a reflog are recreated from its last entry.
+func refObjects(gitDir string, objs map[string]bool) error {
 	files := []string{
-		utils.Url(baseDir, ".git/packed-refs"),
-		utils.Url(baseDir, ".git/info/refs"),
-		utils.Url(baseDir, ".git/info/grafts"),
-		// utils.Url(baseDir, ".git/info/sparse-checkout"), // TODO: ?
-		utils.Url(baseDir, ".git/FETCH_HEAD"),
-		utils.Url(baseDir, ".git/ORIG_HEAD"),
-		utils.Url(baseDir, ".git/HEAD"),
-		utils.Url(baseDir, ".git/objects/loose-object-idx"), // TODO: is this even a text file?
-		utils.Url(baseDir, ".git/objects/info/commit-graphs/commit-graph-chain"),
-		utils.Url(baseDir, ".git/objects/info/alternates"),
-		utils.Url(baseDir, ".git/objects/info/http-alternates"),
+		utils.Url(gitDir, "packed-refs"),
+		utils.Url(gitDir, "info/refs"),
+		utils.Url(gitDir, "info/grafts"),
+		utils.Url(gitDir, "shallow"),
+		// utils.Url(gitDir, "info/sparse-checkout"), // TODO: ?
+		utils.Url(gitDir, "FETCH_HEAD"),
+		utils.Url(gitDir, "ORIG_HEAD"),
+		utils.Url(gitDir, "HEAD"),
+		utils.Url(gitDir, "objects/info/commit-graphs/commit-graph-chain"),
+	}
+	for _, ref := range operationRefs {
+		files = append(files, utils.Url(gitDir, ref))
+	}
+	for _, f := range bisectFiles {
+		files = append(files, utils.Url(gitDir, f))
+	}
+	for _, dir := range operationDirs {
+		filepath.Walk(utils.Url(gitDir, dir), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
 	}
 
 	// TODO : fix if-else hell in the entire object hash collection code (and get rid of bad early returns)
 
-	gitRefsDir := utils.Url(baseDir, ".git/refs")
+	gitRefsDir := utils.Url(gitDir, "refs")
+	replaceDir := utils.Url(gitRefsDir, "replace") + "/"
 	if utils.Exists(gitRefsDir) {
 		if err := filepath.Walk(gitRefsDir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -243,13 +460,17 @@ func FetchGit(baseUrl, baseDir string) error {
 			}
 			if !info.IsDir() {
 				files = append(files, path)
+				// replace refs are named after the object they replace
+				if name := filepath.Base(path); strings.HasPrefix(path, replaceDir) && objRegex.MatchString(name) {
+					objs[name] = true
+				}
 			}
 			return nil
 		}); err != nil {
 			return err
 		}
 	}
-	gitLogsDir := utils.Url(baseDir, ".git/logs")
+	gitLogsDir := utils.Url(gitDir, "logs")
 	if utils.Exists(gitLogsDir) {
 		refLogPrefix := utils.Url(gitLogsDir, "refs") + "/"
 		if err := filepath.Walk(gitLogsDir, func(path string, info os.FileInfo, err error) error {
@@ -263,16 +484,19 @@ func FetchGit(baseUrl, baseDir string) error {
 					refName := strings.TrimPrefix(path, refLogPrefix)
 					filePath := utils.Url(gitRefsDir, refName)
 					if !utils.Exists(filePath) {
-						log.Info().Str("dir", baseDir).Str("ref", refName).Msg("generating ref file")
+						log.Info().Str("dir", gitDir).Str("ref", refName).Msg("generating ref file")
 
 						content, err := ioutil.ReadFile(path)
 						if err != nil {
-							log.Error().Str("dir", baseDir).Str("ref", refName).Err(err).Msg("couldn't read reflog file")
+							log.Error().Str("dir", gitDir).Str("ref", refName).Err(err).Msg("couldn't read reflog file")
 							return nil
 						}
 
 						// Find the last reflog entry and extract the obj hash and write that to the ref file
 						logObjs := refLogRegex.FindAllSubmatch(content, -1)
+						if len(logObjs) == 0 {
+							return nil
+						}
 						lastEntryObj := logObjs[len(logObjs)-1][1]
 
 						if err := utils.CreateParentFolders(filePath); err != nil {
@@ -307,130 +531,10 @@ func FetchGit(baseUrl, baseDir string) error {
 		}
 	}
 
-	indexPath := utils.Url(baseDir, ".git/index")
-	if utils.Exists(indexPath) {
-		f, err := os.Open(indexPath)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		var idx index.Index
-		decoder := index.NewDecoder(f)
-		if err := decoder.Decode(&idx); err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
-		}
-		for _, entry := range idx.Entries {
-			objs[entry.Hash.String()] = true
-		}
-	}
-
-	objStorage := filesystem.NewObjectStorage(dotgit.New(osfs.New(utils.Url(baseDir, ".git"))), &cache.ObjectLRU{MaxSize: 256})
-	if err := objStorage.ForEachObjectHash(func(hash plumbing.Hash) error {
-		objs[hash.String()] = true
-		encObj, err := objStorage.EncodedObject(plumbing.AnyObject, hash)
-		if err != nil {
-			return err
-
-		}
-		decObj, err := object.DecodeObject(objStorage, encObj)
-		if err != nil {
-			return err
-		}
-		for _, hash := range utils.GetReferencedHashes(decObj) {
-			objs[hash] = true
-		}
-		return nil
-	}); err != nil {
-		log.Error().Str("dir", baseDir).Err(err).Msg("error while processing object files")
-	}
-
-	// Parse stand alone commit graph file
-	parseGraphFile(baseDir, utils.Url(baseDir, ".git/objects/info/commit-graph"), objs)
-
-	// Parse commit graph chains
-	commitGraphList := utils.Url(baseDir, ".git/objects/info/commit-graphs/commit-graph-chain")
-	if utils.Exists(commitGraphList) {
-		var graphFiles []string
-		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-		f, err := os.Open(commitGraphList)
-		if err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("failed to open commit graph chain")
-		} else {
-			scanner := bufio.NewScanner(f)
-			for scanner.Scan() {
-				line := strings.TrimSpace(scanner.Text())
-				if !strings.HasPrefix(line, "#") {
-					graphFile := fmt.Sprintf(".git/objects/info/commit-graphs/graph-%s.graph", line)
-					graphFiles = append(graphFiles, graphFile)
-					jt.AddJob(graphFile)
-				}
-			}
-		}
-		jt.StartAndWait(workers.DownloadContext{C: c, BaseDir: baseDir, BaseUrl: baseUrl}, false)
-		for _, graphFile := range graphFiles {
-			parseGraphFile(baseDir, utils.Url(baseDir, graphFile), objs)
-		}
-	}
-
-	// TODO: find more objects to fetch in pack files and remove packed objects from list of objects to be fetched
-	/* packs, err := objStorage.ObjectPacks()
-	// TODO: handle error
-	if err == nil {
-		for _, pack := range packs {
-			pf := utils.Url(baseDir, fmt.Sprintf(".git/objects/pack/pack-%s.pack", pack))
-			r, err := os.Open(pf)
-			if err != nil {
-				log.Error().Str("dir", baseDir).Str("pack", pf).Err(err).Msg("failed to open pack file")
-				continue
-			}
-			sc := packfile.NewScanner(r)
-			for {
-				oh, err := sc.NextObjectHeader()
-				if err != nil {
-					log.Error().Str("dir", baseDir).Str("pack", pf).Err(err).Msg("error while parsing pack file")
-					break
-				}
-			}
-		}
-	} */
-
-	log.Info().Str("base", baseUrl).Msg("fetching objects")
-	jt = jobtracker.NewJobTracker(workers.FindObjectsWorker, maxConcurrency, jobtracker.DefaultNapper)
-	for obj := range objs {
-		jt.AddJob(obj)
-	}
-	jt.StartAndWait(workers.FindObjectsContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir, Storage: objStorage}, true)
-
-	// exit early if we haven't managed to dump anything
-	if !utils.Exists(baseDir) {
-		return nil
-	}
-
-	fetchMissing(baseDir, baseUrl, objStorage)
-
-	// TODO: disable lfs in checkout (for now lfs support depends on lfs NOT being setup on the system you use goop on)
-	if err := checkout(baseDir); err != nil {
-		log.Error().Str("dir", baseDir).Err(err).Msg("failed to checkout")
-	}
-
-	// <fetch lfs objects and manually check them out>
-	fetchLfs(baseDir, baseUrl)
-
-	if err := fetchIgnored(baseDir, baseUrl); err != nil {
-		return err
-	}
-
 	return nil
 }
 
-func checkout(baseDir string) error {
-	log.Info().Str("dir", baseDir).Msg("running git checkout .")
-	cmd := exec.Command("git", "checkout", ".")
-	cmd.Dir = baseDir
-	return cmd.Run()
-}
-
-func fetchLfs(baseDir, baseUrl string) {
+func fetchLfs(baseDir, gitDir, gitUrl string) {
 	attrPath := utils.Url(baseDir, ".gitattributes")
 	if utils.Exists(attrPath) {
 		log.Info().Str("dir", baseDir).Msg("attempting to fetch potential git lfs objects")
@@ -520,64 +624,51 @@ func fetchLfs(baseDir, baseUrl string) {
 
 		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
 		for _, hash := range hashes {
-			jt.AddJob(fmt.Sprintf(".git/lfs/objects/%s/%s/%s", hash[:2], hash[2:4], hash))
+			jt.AddJob(fmt.Sprintf("lfs/objects/%s/%s/%s", hash[:2], hash[2:4], hash))
 		}
-		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir}, false)
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir}, false)
 	}
 }
 
 // Iterate over index to find missing files
-func fetchMissing(baseDir, baseUrl string, objStorage *filesystem.ObjectStorage) {
-	indexPath := utils.Url(baseDir, ".git/index")
-	if utils.Exists(indexPath) {
+func fetchMissing(baseDir, baseUrl, gitDir, gitUrl string, format gitfmt.ObjectFormat) {
+	idx := readIndex(gitDir, gitUrl, format)
+	if idx != nil {
 		log.Info().Str("base", baseUrl).Str("dir", baseDir).Msg("attempting to fetch potentially missing files")
 
 		var missingFiles []string
-		var idx index.Index
-		f, err := os.Open(indexPath)
-		if err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't read git index")
-			return
-		}
-		defer f.Close()
-		decoder := index.NewDecoder(f)
-		if err := decoder.Decode(&idx); err != nil {
-			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't decode git index")
-			return
-		} else {
-			jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-			for _, entry := range idx.Entries {
-				if !strings.HasSuffix(entry.Name, ".php") && !utils.Exists(utils.Url(baseDir, fmt.Sprintf(".git/objects/%s/%s", entry.Hash.String()[:2], entry.Hash.String()[2:]))) {
-					missingFiles = append(missingFiles, entry.Name)
-					jt.AddJob(entry.Name)
-				}
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, entry := range idx.Entries {
+			if filemode.FileMode(entry.Mode) == filemode.Submodule {
+				continue
+			}
+			if !strings.HasSuffix(entry.Name, ".php") && !utils.Exists(utils.Url(gitDir, gitfmt.LooseObjectPath(entry.Hash))) {
+				missingFiles = append(missingFiles, entry.Name)
+				jt.AddJob(entry.Name)
 			}
-			jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir, AllowHtml: true, AlllowEmpty: true}, false)
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir, AllowHtml: true, AlllowEmpty: true}, false)
 
-			jt = jobtracker.NewJobTracker(workers.CreateObjectWorker, maxConcurrency, jobtracker.DefaultNapper)
-			for _, f := range missingFiles {
-				if utils.Exists(utils.Url(baseDir, f)) {
-					jt.AddJob(f)
-				}
+		jt = jobtracker.NewJobTracker(workers.CreateObjectWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for _, f := range missingFiles {
+			if utils.Exists(utils.Url(baseDir, f)) {
+				jt.AddJob(f)
 			}
-			jt.StartAndWait(workers.CreateObjectContext{BaseDir: baseDir, Storage: objStorage, Index: &idx}, false)
 		}
+		jt.StartAndWait(workers.CreateObjectContext{BaseDir: baseDir, GitDir: gitDir, Format: format, Index: idx}, false)
 	}
 }
 
-func fetchIgnored(baseDir, baseUrl string) error {
+func fetchIgnored(baseDir, baseUrl string, untracked []string) error {
+	var files []string
 	ignorePath := utils.Url(baseDir, ".gitignore")
 	if utils.Exists(ignorePath) {
-		log.Info().Str("base", baseDir).Msg("atempting to fetch ignored files")
-
 		ignoreFile, err := os.Open(ignorePath)
 		if err != nil {
 			return err
 		}
 		defer ignoreFile.Close()
 
-		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
-
 		scanner := bufio.NewScanner(ignoreFile)
 		for scanner.Scan() {
 			line := strings.TrimSpace(scanner.Text())
@@ -586,45 +677,147 @@ func fetchIgnored(baseDir, baseUrl string) error {
 			if line == "" || strings.HasPrefix(line, "!") || strings.HasSuffix(line, "/") || strings.ContainsRune(line, '*') || strings.HasSuffix(line, ".php") || strings.HasPrefix(line, "#") {
 				continue
 			}
-			jt.AddJob(line)
+			files = append(files, line)
 		}
 
 		if err := scanner.Err(); err != nil {
 			return err
 		}
+	}
 
+	for _, f := range untracked {
+		if !strings.HasSuffix(f, ".php") {
+			files = append(files, f)
+		}
+	}
+
+	if len(files) > 0 {
+		log.Info().Str("base", baseDir).Msg("atempting to fetch ignored and untracked files")
+		jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		jt.AddJobs(files...)
 		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: baseUrl, BaseDir: baseDir, AllowHtml: true, AlllowEmpty: true}, false)
 	}
 	return nil
 }
 
-func parseGraphFile(baseDir, graphFile string, objs map[string]bool) {
+func parseGraphFile(gitDir, graphFile string, format gitfmt.ObjectFormat, objs map[string]bool) {
 	if utils.Exists(graphFile) {
-		f, err := os.Open(graphFile)
+		data, err := ioutil.ReadFile(graphFile)
 		if err != nil {
-			log.Error().Str("dir", baseDir).Str("graph", graphFile).Err(err).Msg("failed to open commit graph")
+			log.Error().Str("dir", gitDir).Str("graph", graphFile).Err(err).Msg("failed to open commit graph")
 			return
 		}
-		graph, err := commitgraph.OpenFileIndex(f)
+		graph, err := gitfmt.DecodeCommitGraph(data, format)
 		if err != nil {
-			log.Error().Str("dir", baseDir).Str("graph", graphFile).Err(err).Msg("failed to decode commit graph")
+			log.Error().Str("dir", gitDir).Str("graph", graphFile).Err(err).Msg("failed to decode commit graph")
 			return
 		}
-		for _, hash := range graph.Hashes() {
-			objs[hash.String()] = true
-			i, err := graph.GetIndexByHash(hash)
-			if err != nil {
-				log.Error().Str("dir", baseDir).Str("graph", graphFile).Str("commit", hash.String()).Err(err).Msg("failed get index from graph")
-				continue
-			}
-			data, err := graph.GetCommitDataByIndex(i)
-			if err != nil {
-				log.Error().Str("dir", baseDir).Str("graph", graphFile).Str("commit", hash.String()).Err(err).Msg("failed get commit data from graph")
-				continue
+		for _, hash := range graph.Commits {
+			objs[hash] = true
+		}
+		for _, hash := range graph.Trees {
+			objs[hash] = true
+		}
+	}
+}
+
+// scanPacks reads every object contained in the fetched pack files, adding the
+// objects they reference to objs, and returns the set of packed objects.
+func scanPacks(gitDir string, format gitfmt.ObjectFormat, grafts gitfmt.Grafts, objs map[string]bool, miner *refMiner) map[string]bool {
+	packed := make(map[string]bool)
+	packFiles, err := filepath.Glob(utils.Url(gitDir, "objects/pack/pack-*.pack"))
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("failed to list pack files")
+		return packed
+	}
+	readLoose := func(hash string) (gitfmt.ObjectType, []byte, error) {
+		return gitfmt.ReadLooseObject(utils.Url(gitDir, gitfmt.LooseObjectPath(hash)))
+	}
+	for _, pf := range packFiles {
+		log.Info().Str("dir", gitDir).Str("pack", pf).Msg("reading objects from pack file")
+		unresolved, err := gitfmt.ScanPack(pf, format, readLoose, func(hash string, typ gitfmt.ObjectType, content []byte) error {
+			packed[hash] = true
+			for _, ref := range grafts.ReferencedHashes(format, hash, typ, content) {
+				objs[ref] = true
 			}
-			objs[data.TreeHash.String()] = true
+			miner.mineObject(typ, content)
+			return nil
+		})
+		if err != nil {
+			log.Error().Str("dir", gitDir).Str("pack", pf).Err(err).Msg("error while parsing pack file")
+		}
+		if unresolved > 0 {
+			log.Warn().Str("dir", gitDir).Str("pack", pf).Int("count", unresolved).Msg("couldn't resolve some deltas in pack file")
+		}
+	}
+	return packed
+}
+
+func readMultiPackIndex(gitDir string, format gitfmt.ObjectFormat) *gitfmt.MultiPackIndex {
+	midxPath := utils.Url(gitDir, "objects/pack/multi-pack-index")
+	if !utils.Exists(midxPath) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(midxPath)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("failed to read multi-pack-index")
+		return nil
+	}
+	midx, err := gitfmt.DecodeMultiPackIndex(data, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("failed to decode multi-pack-index")
+		return nil
+	}
+	return midx
+}
 
+func readLooseObjectMap(gitDir string, format gitfmt.ObjectFormat) *gitfmt.LooseObjectMap {
+	mapPath := utils.Url(gitDir, "objects/loose-object-idx")
+	if !utils.Exists(mapPath) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(mapPath)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("failed to read loose object map")
+		return nil
+	}
+	looseMap, err := gitfmt.DecodeLooseObjectMap(data, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("failed to decode loose object map")
+	}
+	if looseMap != nil {
+		log.Info().Str("dir", gitDir).Int("count", len(looseMap.Objects)).Msg("read loose object map")
+	}
+	return looseMap
+}
+
+// readGrafts collects the shallow commits and grafts of the repository, which
+// decide which parents are followed while looking for objects.
+func readGrafts(gitDir string, format gitfmt.ObjectFormat, report *Report) gitfmt.Grafts {
+	grafts := make(gitfmt.Grafts)
+	if content, err := ioutil.ReadFile(utils.Url(gitDir, "shallow")); err == nil {
+		shallow := gitfmt.ParseShallow(content, format)
+		for hash := range shallow {
+			report.Shallow = append(report.Shallow, hash)
+		}
+		grafts.Merge(shallow)
+		log.Info().Str("dir", gitDir).Int("count", len(shallow)).Msg("repository is shallow")
+	}
+	if content, err := ioutil.ReadFile(utils.Url(gitDir, "info/grafts")); err == nil {
+		infoGrafts := gitfmt.ParseGrafts(content, format)
+		for hash := range infoGrafts {
+			report.Grafts = append(report.Grafts, hash)
 		}
+		grafts.Merge(infoGrafts)
+		log.Info().Str("dir", gitDir).Int("count", len(infoGrafts)).Msg("repository has grafts")
 	}
+	return grafts
+}
 
+func readObjectFormat(gitDir string) gitfmt.ObjectFormat {
+	config, err := ioutil.ReadFile(utils.Url(gitDir, "config"))
+	if err != nil {
+		return gitfmt.SHA1
+	}
+	return gitfmt.ParseObjectFormat(config)
 }
@@ -0,0 +1,59 @@
+package goop
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+// readRemotes collects the remotes configured in the config files of gitDir
+// and the files they include, merging remotes spread over several files.
+func readRemotes(gitDir string) []*RemoteReport {
+	var remotes []*RemoteReport
+	byName := make(map[string]*RemoteReport)
+	seen := make(map[string]bool)
+
+	var read func(file string)
+	read = func(file string) {
+		if seen[file] {
+			return
+		}
+		seen[file] = true
+		content, err := ioutil.ReadFile(utils.Url(gitDir, file))
+		if err != nil {
+			return
+		}
+		parsed, err := gitfmt.ParseRemotes(content)
+		if err != nil {
+			log.Error().Str("dir", gitDir).Str("file", file).Err(err).Msg("failed to parse git config")
+			return
+		}
+		for _, r := range parsed {
+			rep, ok := byName[r.Name]
+			if !ok {
+				rep = &RemoteReport{Name: r.Name}
+				byName[r.Name] = rep
+				remotes = append(remotes, rep)
+			}
+			rep.Urls = appendUnique(rep.Urls, r.Urls...)
+			rep.PushUrls = appendUnique(rep.PushUrls, r.PushUrls...)
+			rep.Fetch = appendUnique(rep.Fetch, r.Fetch...)
+		}
+		for _, include := range gitfmt.ConfigIncludes(content) {
+			if strings.HasPrefix(include, "/") || strings.HasPrefix(include, "~") {
+				continue
+			}
+			include = path.Join(path.Dir(file), include)
+			if include != ".." && !strings.HasPrefix(include, "../") {
+				read(include)
+			}
+		}
+	}
+	read("config")
+	read("config.worktree")
+	return remotes
+}
@@ -0,0 +1,427 @@
+package goop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/phuslu/log"
+)
+
+// exportReportName is written to the export directory
+const exportReportName = "export.json"
+
+var errBeyondSymlink = errors.New("path leads through a symlink")
+
+// ExportedCommit describes the snapshot written for a commit, Missing lists
+// the paths whose objects weren't recovered, directories ending in a slash.
+type ExportedCommit struct {
+	Commit   string   `json:"commit"`
+	Date     string   `json:"date"`
+	Subject  string   `json:"subject"`
+	Output   string   `json:"output"`
+	Complete bool     `json:"complete"`
+	Missing  []string `json:"missing,omitempty"`
+}
+
+// Export writes the tree of every commit of the repository dumped to dir into
+// outDir, one directory or archive (zip or tar.gz) per commit. With paths only
+// the commits touching those paths are exported.
+func Export(dir, outDir string, paths []string, archive string, force bool) error {
+	switch archive {
+	case "", "zip", "tar.gz":
+	default:
+		return fmt.Errorf("unsupported archive format %s", archive)
+	}
+	gitDir, err := findGitDir(dir)
+	if err != nil {
+		return err
+	}
+	if utils.Exists(outDir) {
+		isEmpty, err := utils.IsEmpty(outDir)
+		if err != nil {
+			return err
+		}
+		if !isEmpty {
+			if !force {
+				return fmt.Errorf("%s is not empty", outDir)
+			}
+			if err := os.RemoveAll(outDir); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	format := readObjectFormat(gitDir)
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	commits := allCommits(gitDir, format, store)
+	log.Info().Str("dir", gitDir).Int("count", len(commits)).Msg("exporting commits")
+
+	var exported []*ExportedCommit
+	for _, hash := range commits {
+		_, content, _ := store.ReadObject(hash)
+		commit := gitfmt.ParseCommit(content)
+		if len(paths) > 0 && !touchesPaths(store, commit, paths) {
+			continue
+		}
+		name := fmt.Sprintf("%s_%s", commit.Time.Format("20060102-150405"), hash)
+		e := &ExportedCommit{
+			Commit:  hash,
+			Date:    commit.Time.Format("2006-01-02T15:04:05Z"),
+			Subject: strings.SplitN(strings.TrimSpace(commit.Message), "\n", 2)[0],
+		}
+		w, err := newSnapshotWriter(outDir, name, archive, commit.Time)
+		if err != nil {
+			log.Error().Str("commit", hash).Err(err).Msg("couldn't create snapshot")
+			continue
+		}
+		e.Output = w.output()
+		e.Missing = writeSnapshot(store, commit.Tree, "", w)
+		if err := w.Close(); err != nil {
+			log.Error().Str("commit", hash).Err(err).Msg("couldn't write snapshot")
+		}
+		e.Complete = len(e.Missing) == 0
+		if !e.Complete {
+			log.Warn().Str("commit", hash).Int("missing", len(e.Missing)).Msg("exported incomplete snapshot")
+		}
+		exported = append(exported, e)
+	}
+
+	data, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return err
+	}
+	fp := utils.Url(outDir, exportReportName)
+	if err := ioutil.WriteFile(fp, data, os.ModePerm); err != nil {
+		return err
+	}
+	log.Info().Str("dir", outDir).Int("count", len(exported)).Msg("exported commits")
+	return nil
+}
+
+// findGitDir returns the git directory of a dumped worktree, or dir itself if
+// it is a git directory.
+func findGitDir(dir string) (string, error) {
+	if utils.Exists(utils.Url(dir, "HEAD")) && utils.IsFolder(utils.Url(dir, "objects")) {
+		return dir, nil
+	}
+	dotGit := utils.Url(dir, ".git")
+	if utils.IsFolder(dotGit) {
+		return dotGit, nil
+	}
+	content, err := ioutil.ReadFile(dotGit)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a git repository", dir)
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(string(content), "gitdir:"))
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return target, nil
+}
+
+// allCommits returns the commits reachable from any ref or reflog, oldest first.
+func allCommits(gitDir string, format gitfmt.ObjectFormat, store *gitfmt.Store) []string {
+	objs := make(map[string]bool)
+	if err := refObjects(gitDir, objs); err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("error while processing refs")
+	}
+	var queue []string
+	for hash := range objs {
+		queue = append(queue, hash)
+	}
+	seen := make(map[string]bool)
+	times := make(map[string]int64)
+	var commits []string
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		typ, content, err := store.ReadObject(hash)
+		if err != nil {
+			continue
+		}
+		switch typ {
+		case gitfmt.TagObject:
+			for _, target := range gitfmt.ReferencedHashes(format, typ, content) {
+				queue = append(queue, target)
+			}
+		case gitfmt.CommitObject:
+			commit := gitfmt.ParseCommit(content)
+			commits = append(commits, hash)
+			times[hash] = commit.Time.Unix()
+			queue = append(queue, commit.Parents...)
+		}
+	}
+	sort.Slice(commits, func(i, j int) bool {
+		if times[commits[i]] != times[commits[j]] {
+			return times[commits[i]] < times[commits[j]]
+		}
+		return commits[i] < commits[j]
+	})
+	return commits
+}
+
+// touchesPaths reports whether any of the paths differs between the commit
+// and its first parent. Commits that can't be compared are included.
+func touchesPaths(store *gitfmt.Store, commit *gitfmt.Commit, paths []string) bool {
+	var parentTree string
+	if len(commit.Parents) > 0 {
+		_, content, err := store.ReadObject(commit.Parents[0])
+		if err != nil {
+			return true
+		}
+		parentTree = gitfmt.ParseCommit(content).Tree
+	}
+	for _, p := range paths {
+		p = strings.Trim(p, "/")
+		hash, err := treeEntryHash(store, commit.Tree, p)
+		if err != nil {
+			return true
+		}
+		var parentHash string
+		if parentTree != "" {
+			if parentHash, err = treeEntryHash(store, parentTree, p); err != nil {
+				return true
+			}
+		}
+		if hash != parentHash {
+			return true
+		}
+	}
+	return false
+}
+
+// treeEntryHash returns the hash of the object at p in the tree, or an empty
+// string if there is nothing at p.
+func treeEntryHash(store *gitfmt.Store, tree, p string) (string, error) {
+	hash := tree
+	for _, part := range strings.Split(p, "/") {
+		entries, err := store.ReadTree(hash)
+		if err != nil {
+			return "", err
+		}
+		hash = ""
+		for _, e := range entries {
+			if e.Name == part {
+				hash = e.Hash
+				break
+			}
+		}
+		if hash == "" {
+			return "", nil
+		}
+	}
+	return hash, nil
+}
+
+// writeSnapshot writes the tree to w, returning the paths whose objects are missing.
+func writeSnapshot(store *gitfmt.Store, tree, prefix string, w snapshotWriter) []string {
+	entries, err := store.ReadTree(tree)
+	if err != nil {
+		return []string{prefix + "/"}
+	}
+	var missing []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.Name == "" || e.Name == "." || e.Name == ".." || strings.EqualFold(e.Name, ".git") || strings.ContainsAny(e.Name, "/\\") {
+			log.Warn().Str("tree", tree).Str("name", e.Name).Msg("skipping unsafe tree entry")
+			continue
+		}
+		// a symlink followed by a tree of the same name would lead out of the snapshot
+		if seen[e.Name] {
+			log.Warn().Str("tree", tree).Str("name", e.Name).Msg("skipping duplicate tree entry")
+			continue
+		}
+		seen[e.Name] = true
+		p := path.Join(prefix, e.Name)
+		mode := filemode.FileMode(e.Mode)
+		switch mode {
+		case filemode.Dir:
+			missing = append(missing, writeSnapshot(store, e.Hash, p, w)...)
+			continue
+		case filemode.Submodule:
+			if err := w.dir(p); err != nil {
+				log.Error().Str("path", p).Err(err).Msg("couldn't write directory")
+			}
+			continue
+		}
+		typ, content, err := store.ReadObject(e.Hash)
+		if err != nil || typ != gitfmt.BlobObject {
+			missing = append(missing, p)
+			continue
+		}
+		if err := w.file(p, mode, content); err != nil {
+			log.Error().Str("path", p).Err(err).Msg("couldn't write file")
+		}
+	}
+	return missing
+}
+
+// snapshotWriter writes the files of a commit to a directory or an archive.
+type snapshotWriter interface {
+	output() string
+	dir(name string) error
+	file(name string, mode filemode.FileMode, content []byte) error
+	Close() error
+}
+
+// archive entries are dated like the commit
+func newSnapshotWriter(outDir, name, archive string, when time.Time) (snapshotWriter, error) {
+	if archive == "" {
+		dir := utils.Url(outDir, name)
+		return &dirWriter{root: dir}, os.MkdirAll(dir, os.ModePerm)
+	}
+	fp := utils.Url(outDir, name+"."+archive)
+	f, err := os.Create(fp)
+	if err != nil {
+		return nil, err
+	}
+	if archive == "zip" {
+		return &zipWriter{f: f, w: zip.NewWriter(f), when: when}, nil
+	}
+	gz := gzip.NewWriter(f)
+	return &tarWriter{f: f, gz: gz, w: tar.NewWriter(gz), when: when}, nil
+}
+
+type dirWriter struct {
+	root string
+}
+
+func (d *dirWriter) output() string {
+	return d.root
+}
+
+func (d *dirWriter) dir(name string) error {
+	if hasSymlinkParent(d.root, name) {
+		return errBeyondSymlink
+	}
+	return os.MkdirAll(utils.Url(d.root, name), os.ModePerm)
+}
+
+func (d *dirWriter) file(name string, mode filemode.FileMode, content []byte) error {
+	if hasSymlinkParent(d.root, name) {
+		return errBeyondSymlink
+	}
+	fp := utils.Url(d.root, name)
+	if err := utils.CreateParentFolders(fp); err != nil {
+		return err
+	}
+	if mode == filemode.Symlink {
+		if err := os.Symlink(string(content), fp); err == nil {
+			return nil
+		}
+	}
+	osMode, err := mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fp, content, osMode.Perm())
+}
+
+func (d *dirWriter) Close() error {
+	return nil
+}
+
+type zipWriter struct {
+	f    *os.File
+	w    *zip.Writer
+	when time.Time
+}
+
+func (z *zipWriter) output() string {
+	return z.f.Name()
+}
+
+func (z *zipWriter) dir(name string) error {
+	_, err := z.w.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: z.when})
+	return err
+}
+
+func (z *zipWriter) file(name string, mode filemode.FileMode, content []byte) error {
+	osMode, err := mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: z.when}
+	header.SetMode(osMode)
+	fw, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(content)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	if err := z.w.Close(); err != nil {
+		z.f.Close()
+		return err
+	}
+	return z.f.Close()
+}
+
+type tarWriter struct {
+	f    *os.File
+	gz   *gzip.Writer
+	w    *tar.Writer
+	when time.Time
+}
+
+func (t *tarWriter) output() string {
+	return t.f.Name()
+}
+
+func (t *tarWriter) dir(name string) error {
+	return t.w.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0755, ModTime: t.when})
+}
+
+func (t *tarWriter) file(name string, mode filemode.FileMode, content []byte) error {
+	if mode == filemode.Symlink {
+		return t.w.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: string(content), Mode: 0777, ModTime: t.when})
+	}
+	osMode, err := mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: int64(osMode.Perm()), Size: int64(len(content)), ModTime: t.when}
+	if err := t.w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = t.w.Write(content)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	errs := []error{t.w.Close(), t.gz.Close(), t.f.Close()}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package goop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const exportRepo = `
+git init -q
+echo a > a.txt
+git add .
+git commit -qm first
+mkdir dir
+echo b > dir/b.txt
+printf '#!/bin/sh\n' > run.sh
+chmod +x run.sh
+ln -s dir/b.txt link
+git add .
+git commit -qm second
+`
+
+// readExport returns the commits listed in the export report of outDir.
+func readExport(t *testing.T, outDir string) []*ExportedCommit {
+	t.Helper()
+	var exported []*ExportedCommit
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(outDir, exportReportName))), &exported); err != nil {
+		t.Fatal(err)
+	}
+	return exported
+}
+
+func TestExport(t *testing.T) {
+	dir := gitRepo(t, exportRepo)
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := Export(dir, outDir, nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	exported := readExport(t, outDir)
+	want := []string{gitOutput(t, dir, "rev-parse", "HEAD^"), gitOutput(t, dir, "rev-parse", "HEAD")}
+	// both commits have the same date, so they are ordered by hash
+	sort.Strings(want)
+	if len(exported) != len(want) {
+		t.Fatalf("got %d commits, want %d", len(exported), len(want))
+	}
+	for i, e := range exported {
+		if e.Commit != want[i] || !e.Complete || e.Date != "2020-09-13T12:26:40Z" {
+			t.Errorf("got %+v", e)
+		}
+		if e.Subject == "second" {
+			snapshot := e.Output
+			if got := readFile(t, filepath.Join(snapshot, "dir/b.txt")); got != "b\n" {
+				t.Errorf("dir/b.txt: got %q", got)
+			}
+			if fi, err := os.Stat(filepath.Join(snapshot, "run.sh")); err != nil || fi.Mode()&0100 == 0 {
+				t.Errorf("run.sh isn't executable: %v", err)
+			}
+			if target, err := os.Readlink(filepath.Join(snapshot, "link")); err != nil || target != "dir/b.txt" {
+				t.Errorf("link: got %q, %v", target, err)
+			}
+		}
+	}
+
+	if err := Export(dir, outDir, nil, "", false); err == nil {
+		t.Error("exported into a directory that isn't empty")
+	}
+	if err := Export(dir, outDir, []string{"dir/"}, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if exported := readExport(t, outDir); len(exported) != 1 || exported[0].Subject != "second" {
+		t.Errorf("got %+v, want only the commit touching dir", exported)
+	}
+}
+
+func TestExportArchives(t *testing.T) {
+	dir := gitRepo(t, exportRepo)
+	want := []string{"a.txt", "dir/b.txt", "link", "run.sh"}
+	for _, archive := range []string{"zip", "tar.gz"} {
+		t.Run(archive, func(t *testing.T) {
+			outDir := t.TempDir()
+			if err := Export(dir, outDir, []string{"dir"}, archive, false); err != nil {
+				t.Fatal(err)
+			}
+			exported := readExport(t, outDir)
+			if len(exported) != 1 {
+				t.Fatalf("got %d commits, want 1", len(exported))
+			}
+			var names []string
+			if archive == "zip" {
+				r, err := zip.OpenReader(exported[0].Output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+				for _, f := range r.File {
+					names = append(names, f.Name)
+				}
+			} else {
+				f, err := os.Open(exported[0].Output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				gz, err := gzip.NewReader(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := tar.NewReader(gz)
+				for {
+					h, err := r.Next()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					names = append(names, h.Name)
+				}
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, want) {
+				t.Errorf("got %q, want %q", names, want)
+			}
+		})
+	}
+}
+
+func TestExportUnsafeTree(t *testing.T) {
+	outside := t.TempDir()
+	// a symlink leading outside followed by a tree of the same name
+	dir := gitRepo(t, `
+git init -q
+blob=$(echo pwned | git hash-object -w --stdin)
+link=$(printf '`+outside+`' | git hash-object -w --stdin)
+sub=$(printf "100644 blob $blob\tpwned\n" | git mktree)
+tree=$(printf "120000 blob $link\tx\n040000 tree $sub\tx\n" | git mktree)
+git update-ref HEAD $(git commit-tree -m evil $tree)
+`)
+	outDir := t.TempDir()
+	if err := Export(dir, outDir, nil, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "pwned")); err == nil {
+		t.Error("wrote through the symlink")
+	}
+	if exported := readExport(t, outDir); len(exported) != 1 {
+		t.Errorf("got %+v", exported)
+	}
+}
@@ -0,0 +1,164 @@
+package goop
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+const (
+	// numbers probed beyond the highest one already seen, or from 1 if none were seen
+	forgeProbeMargin = 25
+	// upper bound for the amount of numbers probed per namespace
+	maxForgeProbes = 2000
+	// gerrit patch sets are probed one after another until one is missing
+	maxPatchSets = 50
+)
+
+// forgeNamespace is a ref namespace code review branches are kept in by a forge.
+type forgeNamespace struct {
+	forge string
+	// substrings of remote urls identifying the forge
+	hosts []string
+	// matches the review number in ref names
+	regex *regexp.Regexp
+	ref   func(n, patchSet int) string
+	// whether refs are further split into patch sets
+	patchSets bool
+}
+
+var forgeNamespaces = []forgeNamespace{
+	{
+		forge: "github",
+		hosts: []string{"github"},
+		regex: regexp.MustCompile(`refs/pull/(\d+)/`),
+		ref:   func(n, _ int) string { return fmt.Sprintf("refs/pull/%d/head", n) },
+	},
+	{
+		forge: "gitlab",
+		hosts: []string{"gitlab"},
+		regex: regexp.MustCompile(`refs/merge-requests/(\d+)/`),
+		ref:   func(n, _ int) string { return fmt.Sprintf("refs/merge-requests/%d/head", n) },
+	},
+	{
+		forge:     "gerrit",
+		hosts:     []string{"gerrit", ":29418", "googlesource.com", "review."},
+		regex:     regexp.MustCompile(`refs/changes/\d{2}/(\d+)/`),
+		ref:       func(n, patchSet int) string { return fmt.Sprintf("refs/changes/%02d/%d/%d", n%100, n, patchSet) },
+		patchSets: true,
+	},
+}
+
+// probeForgeRefs looks for the code review refs of the forges the repository
+// was fetched from, detected from remote urls and refs seen so far. Review
+// numbers up to a little past the highest one seen are probed, both in the
+// forge's namespace and wherever the remote refspecs map it to.
+func probeForgeRefs(gitDir, gitUrl string, report *Report) {
+	remotes := readRemotes(gitDir)
+	seen := seenRefs(gitDir)
+
+	for _, ns := range forgeNamespaces {
+		highest := 0
+		for _, match := range ns.regex.FindAllStringSubmatch(seen, -1) {
+			if n, err := strconv.Atoi(match[1]); err == nil && n > highest {
+				highest = n
+			}
+		}
+		if highest == 0 && !isForgeRemote(ns, remotes) {
+			continue
+		}
+		upper := highest + forgeProbeMargin
+		lower := 1
+		if upper-lower >= maxForgeProbes {
+			lower = upper - maxForgeProbes + 1
+		}
+		log.Info().Str("base", gitUrl).Str("forge", ns.forge).Int("from", lower).Int("to", upper).Msg("probing code review refs")
+
+		pending := make([]int, 0, upper-lower+1)
+		for n := lower; n <= upper; n++ {
+			pending = append(pending, n)
+		}
+		for patchSet := 1; len(pending) > 0 && patchSet <= maxPatchSets; patchSet++ {
+			jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+			for _, n := range pending {
+				jt.AddJobs(mappedRefs(ns.ref(n, patchSet), remotes)...)
+			}
+			jt.StartAndWait(findRefContext(gitUrl, gitDir), true)
+
+			var found []int
+			for _, n := range pending {
+				for _, ref := range mappedRefs(ns.ref(n, patchSet), remotes) {
+					if utils.Exists(utils.Url(gitDir, ref)) {
+						report.ReviewRefs = append(report.ReviewRefs, ref)
+						found = append(found, n)
+						break
+					}
+				}
+			}
+			if !ns.patchSets {
+				break
+			}
+			pending = found
+		}
+	}
+	sort.Strings(report.ReviewRefs)
+}
+
+func isForgeRemote(ns forgeNamespace, remotes []*RemoteReport) bool {
+	for _, remote := range remotes {
+		for _, u := range append(remote.Urls, remote.PushUrls...) {
+			for _, host := range ns.hosts {
+				if strings.Contains(strings.ToLower(u), host) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// mappedRefs returns ref along with the refs the remote refspecs store it as.
+func mappedRefs(ref string, remotes []*RemoteReport) []string {
+	refs := []string{ref}
+	for _, remote := range remotes {
+		for _, spec := range remote.Fetch {
+			if mapped, ok := gitfmt.MapRefspec(spec, ref); ok && mapped != ref && strings.HasPrefix(mapped, "refs/") {
+				refs = append(refs, mapped)
+			}
+		}
+	}
+	return refs
+}
+
+// seenRefs returns the ref names known so far, one per line.
+func seenRefs(gitDir string) string {
+	var sb strings.Builder
+	for _, f := range []string{"packed-refs", "info/refs", "FETCH_HEAD"} {
+		if content, err := ioutil.ReadFile(utils.Url(gitDir, f)); err == nil {
+			sb.Write(content)
+			sb.WriteByte('\n')
+		}
+	}
+	refsDir := utils.Url(gitDir, "refs")
+	filepath.Walk(refsDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			if rel, err := filepath.Rel(gitDir, p); err == nil {
+				sb.WriteString(filepath.ToSlash(rel))
+				sb.WriteByte('\n')
+			}
+		}
+		return nil
+	})
+	return sb.String()
+}
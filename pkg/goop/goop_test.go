@@ -0,0 +1,80 @@
+package goop
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo runs script with sh in a new directory and returns it, skipping the
+// test if git isn't installed. Commits made by the script all have the same
+// author and dates, so their hashes don't change between runs.
+func gitRepo(t *testing.T, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	dir := t.TempDir()
+	cmd := exec.Command("sh", "-e", "-c", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"HOME="+t.TempDir(),
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=goop",
+		"GIT_AUTHOR_EMAIL=goop@example.com",
+		"GIT_AUTHOR_DATE=1600000000 +0000",
+		"GIT_COMMITTER_NAME=goop",
+		"GIT_COMMITTER_EMAIL=goop@example.com",
+		"GIT_COMMITTER_DATE=1600000000 +0000",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	return dir
+}
+
+// gitOutput runs git in dir and returns its trimmed output.
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// serveDir serves the files below root like a web server with directory
+// listings turned off, after passing request paths through rewrite.
+func serveDir(t *testing.T, root string, rewrite func(string) string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if rewrite != nil {
+			p = rewrite(p)
+		}
+		p = filepath.Join(root, filepath.FromSlash(p))
+		if info, err := os.Stat(p); err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, p)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
@@ -0,0 +1,120 @@
+package goop
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/phuslu/log"
+)
+
+// readIndex decodes .git/index, fetching and merging the shared index if the
+// repository uses split index mode.
+func readIndex(gitDir, gitUrl string, format gitfmt.ObjectFormat) *gitfmt.Index {
+	return readIndexFile(gitDir, gitUrl, "index", format)
+}
+
+// readIndexFile is readIndex for an index at the given path relative to the git
+// directory, such as the index of a linked worktree.
+func readIndexFile(gitDir, gitUrl, name string, format gitfmt.ObjectFormat) *gitfmt.Index {
+	indexPath := utils.Url(gitDir, name)
+	if !utils.Exists(indexPath) {
+		return nil
+	}
+	idx, err := gitfmt.ReadIndex(indexPath, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't decode git index")
+	}
+	if idx == nil {
+		return nil
+	}
+
+	link, err := idx.Link()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't decode link extension")
+	}
+	if link == nil {
+		return idx
+	}
+
+	// the shared index lives next to the index linking to it
+	sharedIndex := path.Join(path.Dir(name), fmt.Sprintf("sharedindex.%s", link.SharedIndex))
+	log.Info().Str("dir", gitDir).Str("index", sharedIndex).Msg("index links to a shared index")
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJob(sharedIndex)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir}, false)
+	if !utils.Exists(utils.Url(gitDir, sharedIndex)) {
+		return idx
+	}
+
+	shared, err := gitfmt.ReadIndex(utils.Url(gitDir, sharedIndex), format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Str("index", sharedIndex).Err(err).Msg("couldn't decode shared index")
+	}
+	if shared == nil {
+		return idx
+	}
+	merged, err := gitfmt.MergeSharedIndex(idx, shared)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Str("index", sharedIndex).Err(err).Msg("couldn't merge shared index")
+		return idx
+	}
+	return merged
+}
+
+// indexObjects returns the hashes of all objects mentioned by the index, its
+// cache tree and its resolve undo data.
+func indexObjects(gitDir string, idx *gitfmt.Index) []string {
+	var hashes []string
+	for _, entry := range idx.Entries {
+		// gitlinks point at commits of the submodule repository
+		if filemode.FileMode(entry.Mode) != filemode.Submodule {
+			hashes = append(hashes, entry.Hash)
+		}
+	}
+
+	trees, err := idx.CacheTree()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't decode cache tree extension")
+	}
+	for _, tree := range trees {
+		if tree.Hash != "" {
+			hashes = append(hashes, tree.Hash)
+		}
+	}
+
+	reuc, err := idx.ResolveUndo()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't decode resolve undo extension")
+	}
+	for _, entry := range reuc {
+		for _, hash := range entry.Hashes {
+			if hash != "" {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+
+	return hashes
+}
+
+// indexUntracked returns the untracked files listed in the untracked cache.
+func indexUntracked(gitDir string, idx *gitfmt.Index) []string {
+	untracked, err := idx.Untracked()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't decode untracked cache extension")
+	}
+	var files []string
+	for _, f := range untracked {
+		// directories end in a slash, empty names only come from malformed caches
+		if f != "" && !strings.HasSuffix(f, "/") {
+			files = append(files, f)
+		}
+	}
+	return files
+}
@@ -0,0 +1,78 @@
+package goop
+
+import (
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/phuslu/log"
+)
+
+// rebuildTrees writes the tree objects of the index's directories that are
+// missing from the repository, which mends the tree chain of HEAD when only
+// the index and blobs could be fetched. Only trees matching the hash recorded
+// in the cache tree, or HEAD's tree for the root if that is missing too, are
+// written; trees that don't match are reported instead.
+func rebuildTrees(gitDir, gitUrl string, format gitfmt.ObjectFormat, report *Report) {
+	idx := readIndex(gitDir, gitUrl, format)
+	if idx == nil {
+		return
+	}
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't open object store")
+		return
+	}
+	defer store.Close()
+
+	expected := make(map[string]string)
+	cacheTree, err := idx.CacheTree()
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't decode cache tree extension")
+	}
+	for _, tree := range cacheTree {
+		if tree.Hash != "" {
+			expected[tree.Path] = tree.Hash
+		}
+	}
+	// with HEAD's tree at hand the root differs only because of staged changes
+	_, headCommit := readHead(gitDir, format, store)
+	if _, ok := expected[""]; !ok && headCommit != nil && !store.HasObject(headCommit.Tree) {
+		expected[""] = headCommit.Tree
+	}
+
+	for _, tree := range idx.IndexTrees() {
+		if store.HasObject(tree.Hash) {
+			continue
+		}
+		// nothing says such a tree was ever part of a commit
+		want, known := expected[tree.Path]
+		if !known {
+			continue
+		}
+		if want != tree.Hash {
+			log.Warn().Str("dir", gitDir).Str("path", tree.Path).Str("expected", want).Str("hash", tree.Hash).Msg("rebuilt tree doesn't match the expected tree, not writing it")
+			report.MismatchedTrees = append(report.MismatchedTrees, &TreeReport{Path: tree.Path, Hash: tree.Hash, Expected: want})
+			continue
+		}
+		if _, err := gitfmt.WriteLooseObject(gitDir, format, gitfmt.TreeObject, tree.Content); err != nil {
+			log.Error().Str("dir", gitDir).Str("path", tree.Path).Err(err).Msg("couldn't write tree object")
+			continue
+		}
+		report.RebuiltTrees = append(report.RebuiltTrees, &TreeReport{Path: tree.Path, Hash: tree.Hash})
+	}
+	if len(report.RebuiltTrees) > 0 {
+		log.Info().Str("dir", gitDir).Int("count", len(report.RebuiltTrees)).Msg("rebuilt missing trees from the index")
+	}
+}
+
+// readHead returns the commit HEAD points to, the commit itself being nil if
+// it wasn't recovered.
+func readHead(gitDir string, format gitfmt.ObjectFormat, store *gitfmt.Store) (string, *gitfmt.Commit) {
+	head, err := gitfmt.ResolveRef(gitDir, "HEAD", format)
+	if err != nil {
+		return "", nil
+	}
+	typ, content, err := store.ReadObject(head)
+	if err != nil || typ != gitfmt.CommitObject {
+		return head, nil
+	}
+	return head, gitfmt.ParseCommit(content)
+}
@@ -0,0 +1,86 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+)
+
+func TestRebuildTrees(t *testing.T) {
+	const commit = `
+git init -q
+echo a > a.txt
+mkdir dir other
+echo b > dir/b.txt
+echo c > other/c.txt
+git add .
+git commit -qm init
+`
+	tests := []struct {
+		name   string
+		script string
+		// paths of the trees rebuilt and of those that don't match, children
+		// coming before their parents
+		rebuilt    []string
+		mismatched []string
+	}{
+		{"committed", commit, []string{"dir", "other", ""}, nil},
+		// staging invalidates the cache tree of dir and the root, the root is
+		// still expected to be HEAD's tree
+		{"staged changes", commit + "echo staged > dir/b.txt\ngit add dir/b.txt\n", []string{"other"}, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := gitRepo(t, tt.script)
+			gitDir := filepath.Join(dir, ".git")
+			trees := map[string]string{
+				"":      gitOutput(t, dir, "rev-parse", "HEAD^{tree}"),
+				"dir":   gitOutput(t, dir, "rev-parse", "HEAD:dir"),
+				"other": gitOutput(t, dir, "rev-parse", "HEAD:other"),
+			}
+			for _, hash := range trees {
+				if err := os.Remove(filepath.Join(gitDir, gitfmt.LooseObjectPath(hash))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report := &Report{}
+			rebuildTrees(gitDir, "", gitfmt.SHA1, report)
+			var rebuilt, mismatched []string
+			for _, tree := range report.RebuiltTrees {
+				rebuilt = append(rebuilt, tree.Path)
+				if tree.Hash != trees[tree.Path] {
+					t.Errorf("%q: rebuilt %s, want %s", tree.Path, tree.Hash, trees[tree.Path])
+				}
+			}
+			for _, tree := range report.MismatchedTrees {
+				mismatched = append(mismatched, tree.Path)
+				if tree.Expected != trees[tree.Path] {
+					t.Errorf("%q: expected %s, want %s", tree.Path, tree.Expected, trees[tree.Path])
+				}
+			}
+			if !reflect.DeepEqual(rebuilt, tt.rebuilt) || !reflect.DeepEqual(mismatched, tt.mismatched) {
+				t.Errorf("got rebuilt %q and mismatched %q, want %q and %q", rebuilt, mismatched, tt.rebuilt, tt.mismatched)
+			}
+
+			store, err := gitfmt.OpenStore(gitDir, gitfmt.SHA1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			for _, path := range tt.rebuilt {
+				if !store.HasObject(trees[path]) {
+					t.Errorf("%q: tree wasn't written", path)
+				}
+			}
+			for _, path := range tt.mismatched {
+				if store.HasObject(trees[path]) {
+					t.Errorf("%q: written even though it doesn't match", path)
+				}
+			}
+		})
+	}
+}
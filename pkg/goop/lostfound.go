@@ -0,0 +1,118 @@
+package goop
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+const lostFoundRefPrefix = "refs/goop/lost-found/"
+
+// recoverLostFound points refs/goop/lost-found/<hash> at the tips of the
+// commits that were recovered but aren't reachable from any ref, such as
+// deleted branches or amended commits only left in reflogs and packs.
+func recoverLostFound(gitDir string, format gitfmt.ObjectFormat, report *Report) {
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't open object store")
+		return
+	}
+	defer store.Close()
+
+	commits := make(map[string][]string)
+	if err := store.ForEachObject(func(hash string) error {
+		if _, ok := commits[hash]; ok {
+			return nil
+		}
+		if typ, content, err := store.ReadObject(hash); err == nil && typ == gitfmt.CommitObject {
+			commits[hash] = gitfmt.ParseCommit(content).Parents
+		}
+		return nil
+	}); err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("error while listing objects")
+		return
+	}
+
+	// everything reachable from refs, following tags and parents
+	reachable := make(map[string]bool)
+	queue := refTips(gitDir, format)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if reachable[hash] {
+			continue
+		}
+		reachable[hash] = true
+		if parents, ok := commits[hash]; ok {
+			queue = append(queue, parents...)
+		} else if typ, content, err := store.ReadObject(hash); err == nil && typ == gitfmt.TagObject {
+			queue = append(queue, gitfmt.ReferencedHashes(format, typ, content)...)
+		}
+	}
+
+	// unreachable commits that are the parent of another one aren't tips
+	isParent := make(map[string]bool)
+	for hash, parents := range commits {
+		if !reachable[hash] {
+			for _, parent := range parents {
+				isParent[parent] = true
+			}
+		}
+	}
+	var tips []string
+	for hash := range commits {
+		if !reachable[hash] && !isParent[hash] {
+			tips = append(tips, hash)
+		}
+	}
+	sort.Strings(tips)
+
+	for _, hash := range tips {
+		refPath := utils.Url(gitDir, lostFoundRefPrefix+hash)
+		if err := utils.CreateParentFolders(refPath); err != nil {
+			log.Error().Str("file", refPath).Err(err).Msg("couldn't create parent directories")
+			continue
+		}
+		if err := ioutil.WriteFile(refPath, []byte(hash+"\n"), os.ModePerm); err != nil {
+			log.Error().Str("file", refPath).Err(err).Msg("couldn't write lost-found ref")
+			continue
+		}
+		report.LostFound = append(report.LostFound, hash)
+	}
+	if len(report.LostFound) > 0 {
+		log.Info().Str("dir", gitDir).Int("count", len(report.LostFound)).Msg("recovered unreachable commits into lost-found refs")
+	}
+}
+
+// refTips returns the hashes HEAD, packed-refs and the refs point at, leaving
+// out earlier lost-found refs so they are computed again.
+func refTips(gitDir string, format gitfmt.ObjectFormat) []string {
+	var tips []string
+	files := []string{utils.Url(gitDir, "HEAD"), utils.Url(gitDir, "packed-refs")}
+	lostFoundDir := utils.Url(gitDir, lostFoundRefPrefix)
+	filepath.Walk(utils.Url(gitDir, "refs"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && !strings.HasPrefix(path, lostFoundDir) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	for _, f := range files {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		for _, obj := range objRegex.FindAll(content, -1) {
+			tips = append(tips, strings.TrimSpace(string(obj)))
+		}
+	}
+	if head, err := gitfmt.ResolveRef(gitDir, "HEAD", format); err == nil {
+		tips = append(tips, head)
+	}
+	return tips
+}
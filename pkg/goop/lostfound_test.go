@@ -0,0 +1,56 @@
+package goop
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+)
+
+func TestRecoverLostFound(t *testing.T) {
+	dir := gitRepo(t, `
+git init -q
+echo a > a.txt
+git add .
+git commit -qm init
+git checkout -q -b feature
+echo b > b.txt
+git add .
+git commit -qm feature
+git commit -qm "feature 2" --allow-empty
+git rev-parse HEAD > deleted
+git checkout -q -
+git branch -q -D feature
+git commit -qm amended --allow-empty
+git rev-parse HEAD > amended
+git commit -q --amend --allow-empty -m amend
+rm -rf .git/logs
+`)
+	gitDir := filepath.Join(dir, ".git")
+	want := []string{
+		strings.TrimSpace(readFile(t, filepath.Join(dir, "deleted"))),
+		strings.TrimSpace(readFile(t, filepath.Join(dir, "amended"))),
+	}
+	sort.Strings(want)
+
+	// earlier lost-found refs don't make their commits reachable
+	for i := 0; i < 2; i++ {
+		report := &Report{}
+		recoverLostFound(gitDir, gitfmt.SHA1, report)
+		if !reflect.DeepEqual(report.LostFound, want) {
+			t.Fatalf("got %v, want %v", report.LostFound, want)
+		}
+	}
+	for _, hash := range want {
+		if got := gitOutput(t, dir, "rev-parse", lostFoundRefPrefix+hash); got != hash {
+			t.Errorf("%s: got %s", hash, got)
+		}
+	}
+	if utils.Exists(filepath.Join(gitDir, lostFoundRefPrefix, gitOutput(t, dir, "rev-parse", "HEAD"))) {
+		t.Error("HEAD exposed as lost")
+	}
+}
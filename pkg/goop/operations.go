@@ -0,0 +1,194 @@
+package goop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// git gives up on mailboxes with more patches than this as well
+const maxApplyPatches = 9999
+
+var (
+	// operationRefs are left behind by interrupted merges, rebases, cherry-picks and reverts
+	operationRefs = []string{
+		"MERGE_HEAD",
+		"CHERRY_PICK_HEAD",
+		"REVERT_HEAD",
+		"REBASE_HEAD",
+		"AUTO_MERGE",
+		"rebase-merge/head-name",
+		"rebase-merge/onto",
+		"rebase-merge/orig-head",
+		"rebase-merge/stopped-sha",
+		"rebase-apply/head-name",
+		"rebase-apply/onto",
+		"rebase-apply/orig-head",
+		"sequencer/head",
+	}
+	// operationFiles describe interrupted operations, some of them are empty marker files
+	operationFiles = []string{
+		"MERGE_MSG",
+		"MERGE_MODE",
+		"MERGE_RR",
+		"rebase-merge/git-rebase-todo",
+		"rebase-merge/git-rebase-todo.backup",
+		"rebase-merge/done",
+		"rebase-merge/msgnum",
+		"rebase-merge/end",
+		"rebase-merge/interactive",
+		"rebase-merge/message",
+		"rebase-merge/author-script",
+		"rebase-merge/amend",
+		"rebase-merge/rewritten-list",
+		"rebase-merge/patch",
+		"rebase-apply/next",
+		"rebase-apply/last",
+		"rebase-apply/patch",
+		"rebase-apply/msg",
+		"rebase-apply/final-commit",
+		"rebase-apply/original-commit",
+		"rebase-apply/applying",
+		"rebase-apply/rebasing",
+		"rebase-apply/info",
+		"rebase-apply/author-script",
+		"rebase-apply/rewritten",
+		"sequencer/todo",
+		"sequencer/done",
+		"sequencer/opts",
+		"sequencer/abort-safety",
+	}
+	// operationDirs hold the state of interrupted operations
+	operationDirs = []string{"rebase-merge", "rebase-apply", "sequencer"}
+)
+
+// fetchOperationState fetches the state of interrupted merges, rebases,
+// cherry-picks and reverts, including the patches of git am and apply based
+// rebases, and describes the operations in the report.
+func fetchOperationState(gitDir, gitUrl string, report *Report) {
+	jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(operationRefs...)
+	jt.StartAndWait(findRefContext(gitUrl, gitDir), true)
+
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(operationFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir, AlllowEmpty: true}, false)
+
+	if last := readInt(gitDir, "rebase-apply/last"); last > 0 {
+		if last > maxApplyPatches {
+			last = maxApplyPatches
+		}
+		jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+		for i := 1; i <= last; i++ {
+			jt.AddJob(fmt.Sprintf("rebase-apply/%04d", i))
+		}
+		jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir}, false)
+	}
+
+	for _, kind := range []struct{ name, head string }{
+		{"merge", "MERGE_HEAD"},
+		{"cherry-pick", "CHERRY_PICK_HEAD"},
+		{"revert", "REVERT_HEAD"},
+	} {
+		heads := readLines(gitDir, kind.head)
+		if len(heads) == 0 {
+			continue
+		}
+		op := &OperationReport{Kind: kind.name, Heads: heads}
+		if kind.name == "merge" {
+			op.Message = readString(gitDir, "MERGE_MSG")
+		} else {
+			op.Todo = readTodo(gitDir, "sequencer/todo")
+		}
+		report.Operations = append(report.Operations, op)
+	}
+
+	if utils.Exists(utils.Url(gitDir, "rebase-merge")) {
+		report.Operations = append(report.Operations, &OperationReport{
+			Kind:     "rebase",
+			HeadName: readString(gitDir, "rebase-merge/head-name"),
+			Onto:     readString(gitDir, "rebase-merge/onto"),
+			OrigHead: readString(gitDir, "rebase-merge/orig-head"),
+			Heads:    readLines(gitDir, "rebase-merge/stopped-sha"),
+			Message:  readString(gitDir, "rebase-merge/message"),
+			Step:     readInt(gitDir, "rebase-merge/msgnum"),
+			Total:    readInt(gitDir, "rebase-merge/end"),
+			Todo:     readTodo(gitDir, "rebase-merge/git-rebase-todo"),
+		})
+	}
+
+	if utils.Exists(utils.Url(gitDir, "rebase-apply")) {
+		op := &OperationReport{
+			Kind:     "am",
+			HeadName: readString(gitDir, "rebase-apply/head-name"),
+			Onto:     readString(gitDir, "rebase-apply/onto"),
+			OrigHead: readString(gitDir, "rebase-apply/orig-head"),
+			Heads:    readLines(gitDir, "rebase-apply/original-commit"),
+			Message:  readString(gitDir, "rebase-apply/final-commit"),
+			Step:     readInt(gitDir, "rebase-apply/next"),
+			Total:    readInt(gitDir, "rebase-apply/last"),
+		}
+		if utils.Exists(utils.Url(gitDir, "rebase-apply/rebasing")) {
+			op.Kind = "rebase"
+		}
+		for i := 1; i <= op.Total && i <= maxApplyPatches; i++ {
+			patch := fmt.Sprintf("rebase-apply/%04d", i)
+			if utils.Exists(utils.Url(gitDir, patch)) {
+				op.Patches = append(op.Patches, patch)
+			}
+		}
+		report.Operations = append(report.Operations, op)
+	}
+
+	for _, op := range report.Operations {
+		log.Info().Str("dir", gitDir).Str("operation", op.Kind).Msg("found interrupted operation")
+	}
+}
+
+func readString(gitDir, file string) string {
+	content, err := ioutil.ReadFile(utils.Url(gitDir, file))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func readLines(gitDir, file string) []string {
+	var lines []string
+	for _, line := range strings.Split(readString(gitDir, file), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func readInt(gitDir, file string) int {
+	n, _ := strconv.Atoi(readString(gitDir, file))
+	return n
+}
+
+// readTodo returns the instructions of a rebase or sequencer todo list.
+func readTodo(gitDir, file string) []string {
+	content, err := ioutil.ReadFile(utils.Url(gitDir, file))
+	if err != nil {
+		return nil
+	}
+	var todo []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			todo = append(todo, line)
+		}
+	}
+	return todo
+}
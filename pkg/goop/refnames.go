@@ -0,0 +1,155 @@
+package goop
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+var (
+	// commit and tag messages written by git and the common forges
+	mergeBranchRegex   = regexp.MustCompile(`(?m)^Merge (?:remote-tracking )?branch '([^']+)'(?: into '?([^'\s]+)'?)?`)
+	mergeBranchesRegex = regexp.MustCompile(`(?m)^Merge (?:remote-tracking )?branches (.+)$`)
+	mergeTagRegex      = regexp.MustCompile(`(?m)^Merge tag '([^']+)'`)
+	pullRequestRegex   = regexp.MustCompile(`(?m)^Merge pull request #\d+ from [^/\s]+/(\S+)`)
+	mergedInRegex      = regexp.MustCompile(`(?m)^Merged in (\S+)`)
+	quotedRegex        = regexp.MustCompile(`'([^']+)'`)
+
+	// reflog messages, following the tab after the committer
+	checkoutRegex      = regexp.MustCompile(`(?m)\tcheckout: moving from (\S+) to (\S+)$`)
+	branchCreatedRegex = regexp.MustCompile(`(?m)\tbranch: Created from (\S+)$`)
+	reflogMergeRegex   = regexp.MustCompile(`(?m)\tmerge (\S+): `)
+	resetRegex         = regexp.MustCompile(`(?m)\treset: moving to (\S+)$`)
+	rebaseRegex        = regexp.MustCompile(`(?m)\trebase.*\(start\): checkout (\S+)$`)
+
+	hexRegex = regexp.MustCompile(`^[a-f0-9]{7,}$`)
+)
+
+// refMiner collects the names of branches and tags mentioned in commit
+// messages and reflogs, which aren't found by looking for refs/ paths.
+type refMiner struct {
+	names   map[string]bool
+	pending []string
+	// loose objects that have already been mined
+	mined map[string]bool
+}
+
+func newRefMiner() *refMiner {
+	return &refMiner{
+		names: make(map[string]bool),
+		mined: make(map[string]bool),
+	}
+}
+
+func (m *refMiner) add(name string) {
+	name = strings.TrimSpace(name)
+	if !isRefName(name) || m.names[name] {
+		return
+	}
+	m.names[name] = true
+	m.pending = append(m.pending, name)
+}
+
+// take returns the names found since the last call.
+func (m *refMiner) take() []string {
+	names := m.pending
+	m.pending = nil
+	return names
+}
+
+func (m *refMiner) mineObject(typ gitfmt.ObjectType, content []byte) {
+	if typ != gitfmt.CommitObject && typ != gitfmt.TagObject {
+		return
+	}
+	msg := content
+	if i := bytes.Index(content, []byte("\n\n")); i >= 0 {
+		msg = content[i+2:]
+	}
+	for _, match := range mergeBranchRegex.FindAllSubmatch(msg, -1) {
+		m.add(string(match[1]))
+		if len(match[2]) > 0 {
+			m.add(string(match[2]))
+		}
+	}
+	for _, match := range mergeBranchesRegex.FindAllSubmatch(msg, -1) {
+		for _, quoted := range quotedRegex.FindAllSubmatch(match[1], -1) {
+			m.add(string(quoted[1]))
+		}
+	}
+	for _, re := range []*regexp.Regexp{mergeTagRegex, pullRequestRegex, mergedInRegex} {
+		for _, match := range re.FindAllSubmatch(msg, -1) {
+			m.add(string(match[1]))
+		}
+	}
+}
+
+func (m *refMiner) mineReflog(content []byte) {
+	for _, re := range []*regexp.Regexp{checkoutRegex, branchCreatedRegex, reflogMergeRegex, resetRegex, rebaseRegex} {
+		for _, match := range re.FindAllSubmatch(content, -1) {
+			for _, name := range match[1:] {
+				m.add(string(name))
+			}
+		}
+	}
+}
+
+// mineGitDir mines all reflogs and the loose objects that haven't been mined yet.
+func (m *refMiner) mineGitDir(gitDir string, format gitfmt.ObjectFormat) {
+	filepath.Walk(utils.Url(gitDir, "logs"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if content, err := ioutil.ReadFile(path); err == nil {
+			m.mineReflog(content)
+		}
+		return nil
+	})
+	if err := gitfmt.ForEachLooseObject(gitDir, format, func(hash string) error {
+		if m.mined[hash] {
+			return nil
+		}
+		m.mined[hash] = true
+		typ, content, err := gitfmt.ReadLooseObject(utils.Url(gitDir, gitfmt.LooseObjectPath(hash)))
+		if err == nil {
+			m.mineObject(typ, content)
+		}
+		return nil
+	}); err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("error while mining object files for ref names")
+	}
+}
+
+// isRefName rejects names that can't be refs as well as commit hashes and
+// revision expressions that show up in the same places.
+func isRefName(name string) bool {
+	if name == "HEAD" || strings.HasSuffix(name, "HEAD") && !strings.Contains(name, "/") || hexRegex.MatchString(name) || strings.HasPrefix(name, "-") {
+		return false
+	}
+	return gitfmt.IsRefName(name)
+}
+
+// refNameJobs returns the refs and reflogs a name mentioned somewhere might
+// refer to.
+func refNameJobs(name string) []string {
+	if strings.HasPrefix(name, "refs/") {
+		return []string{name, "logs/" + name}
+	}
+	jobs := []string{
+		"refs/heads/" + name,
+		"logs/refs/heads/" + name,
+		"refs/tags/" + name,
+		"logs/refs/tags/" + name,
+	}
+	if strings.Contains(name, "/") {
+		// names like origin/main usually refer to remote tracking branches
+		jobs = append(jobs, "refs/remotes/"+name, "logs/refs/remotes/"+name)
+	}
+	return jobs
+}
@@ -0,0 +1,143 @@
+package goop
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+// reportPath is relative to the git directory
+const reportPath = "goop/report.json"
+
+// Report collects everything goop learned about a repository that isn't
+// represented in the dumped git directory itself.
+type Report struct {
+	Remotes []*RemoteReport `json:"remotes,omitempty"`
+	// ReviewRefs lists the code review refs found by probing forge namespaces
+	ReviewRefs []string `json:"review_refs,omitempty"`
+	// OriginalRefs lists the refs filter-branch kept from before history was rewritten
+	OriginalRefs []string           `json:"original_refs,omitempty"`
+	Alternates   []*AlternateReport `json:"alternates,omitempty"`
+	// Shallow lists the shallow boundary commits, Grafts the commits with grafted parents
+	Shallow    []string           `json:"shallow,omitempty"`
+	Grafts     []string           `json:"grafts,omitempty"`
+	Submodules []*SubmoduleReport `json:"submodules,omitempty"`
+	Worktrees  []*WorktreeReport  `json:"worktrees,omitempty"`
+	Stashes    []*StashReport     `json:"stashes,omitempty"`
+	Operations []*OperationReport `json:"operations,omitempty"`
+	// MissingFiles lists the index entries whose contents couldn't be recovered
+	MissingFiles []string      `json:"missing_files,omitempty"`
+	RebuiltTrees []*TreeReport `json:"rebuilt_trees,omitempty"`
+	// MismatchedTrees lists the trees rebuilt from the index that weren't written
+	// because they don't match the hash they were expected to have
+	MismatchedTrees []*TreeReport `json:"mismatched_trees,omitempty"`
+	Index           *IndexReport  `json:"index,omitempty"`
+	// LostFound lists the tips of unreachable commits exposed as refs/goop/lost-found/<hash>
+	LostFound []string `json:"lost_found,omitempty"`
+}
+
+type AlternateReport struct {
+	Url     string   `json:"url"`
+	Packs   []string `json:"packs,omitempty"`
+	Objects []string `json:"objects,omitempty"`
+}
+
+type RemoteReport struct {
+	Name     string   `json:"name"`
+	Urls     []string `json:"urls,omitempty"`
+	PushUrls []string `json:"push_urls,omitempty"`
+	Fetch    []string `json:"fetch,omitempty"`
+}
+
+// SubmoduleReport describes a submodule and the commit the superproject pins it to.
+type SubmoduleReport struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Url    string `json:"url,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	Dumped bool   `json:"dumped"`
+}
+
+// StashReport describes a stash along with the commits it is made of, Missing
+// lists those that couldn't be recovered.
+type StashReport struct {
+	Ref       string   `json:"ref"`
+	Commit    string   `json:"commit"`
+	Message   string   `json:"message,omitempty"`
+	Base      string   `json:"base,omitempty"`
+	Index     string   `json:"index,omitempty"`
+	Untracked string   `json:"untracked,omitempty"`
+	Missing   []string `json:"missing,omitempty"`
+}
+
+// OperationReport describes an interrupted merge, rebase, am, cherry-pick or
+// revert. Heads are the commits being merged or picked, Step and Total the
+// progress through Todo or Patches.
+type OperationReport struct {
+	Kind     string   `json:"kind"`
+	Heads    []string `json:"heads,omitempty"`
+	HeadName string   `json:"head_name,omitempty"`
+	Onto     string   `json:"onto,omitempty"`
+	OrigHead string   `json:"orig_head,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Step     int      `json:"step,omitempty"`
+	Total    int      `json:"total,omitempty"`
+	Todo     []string `json:"todo,omitempty"`
+	Patches  []string `json:"patches,omitempty"`
+}
+
+// WorktreeReport describes a linked worktree, Path being its location on the server.
+type WorktreeReport struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+	Head string `json:"head"`
+}
+
+// TreeReport describes a tree rebuilt from the index, the root tree having an
+// empty path.
+type TreeReport struct {
+	Path     string `json:"path"`
+	Hash     string `json:"hash"`
+	Expected string `json:"expected,omitempty"`
+}
+
+// IndexReport describes the commit of the index snapshot written to
+// refs/goop/index and the paths staged relative to HEAD.
+type IndexReport struct {
+	Commit   string   `json:"commit"`
+	Added    []string `json:"added,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Deleted  []string `json:"deleted,omitempty"`
+}
+
+func (r *Report) alternate(u string) *AlternateReport {
+	for _, alt := range r.Alternates {
+		if alt.Url == u {
+			return alt
+		}
+	}
+	alt := &AlternateReport{Url: u}
+	r.Alternates = append(r.Alternates, alt)
+	return alt
+}
+
+func (r *Report) write(gitDir string) {
+	fp := utils.Url(gitDir, reportPath)
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't encode report")
+		return
+	}
+	if err := utils.CreateParentFolders(fp); err != nil {
+		log.Error().Str("file", fp).Err(err).Msg("couldn't create parent directories")
+		return
+	}
+	if err := ioutil.WriteFile(fp, data, os.ModePerm); err != nil {
+		log.Error().Str("file", fp).Err(err).Msg("couldn't write report")
+		return
+	}
+	log.Info().Str("file", fp).Msg("wrote report")
+}
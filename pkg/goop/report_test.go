@@ -0,0 +1,34 @@
+package goop
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReportWrite(t *testing.T) {
+	report := &Report{}
+	report.alternate("https://example.com/a/.git/objects").Packs = []string{"pack-1"}
+	report.alternate("https://example.com/b/.git/objects")
+	report.alternate("https://example.com/a/.git/objects").Packs = append(report.Alternates[0].Packs, "pack-2")
+	if len(report.Alternates) != 2 {
+		t.Fatalf("got %d alternates, want 2", len(report.Alternates))
+	}
+
+	gitDir := t.TempDir()
+	report.write(gitDir)
+	content := readFile(t, filepath.Join(gitDir, reportPath))
+	// fields that weren't set are left out
+	if strings.Contains(content, "submodules") {
+		t.Errorf("got empty fields in %s", content)
+	}
+	got := &Report{}
+	if err := json.Unmarshal([]byte(content), got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Errorf("got %+v, want %+v", got, report)
+	}
+}
@@ -0,0 +1,173 @@
+package goop
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/phuslu/log"
+)
+
+// IndexCommit makes goop write the commit of the index snapshot to
+// refs/goop/index even if nothing is staged.
+var IndexCommit = false
+
+const indexRef = "refs/goop/index"
+
+// commitIndex compares the index with HEAD's tree and, if changes are staged,
+// commits the index snapshot on top of HEAD to refs/goop/index, reporting the
+// paths that were added, modified or deleted.
+func commitIndex(gitDir, gitUrl string, format gitfmt.ObjectFormat, report *Report) {
+	idx := readIndex(gitDir, gitUrl, format)
+	if idx == nil {
+		return
+	}
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't open object store")
+		return
+	}
+	defer store.Close()
+
+	staged := &IndexReport{}
+	head, headCommit := readHead(gitDir, format, store)
+	if headCommit != nil {
+		diffIndex(gitDir, idx, store, headCommit.Tree, staged)
+	} else if head != "" {
+		log.Warn().Str("dir", gitDir).Str("commit", head).Msg("couldn't compare the index with HEAD, its commit wasn't recovered")
+		head = ""
+	} else {
+		// nothing was committed yet, so everything is staged
+		diffIndex(gitDir, idx, store, "", staged)
+	}
+	if len(staged.Added)+len(staged.Modified)+len(staged.Deleted) == 0 && !IndexCommit {
+		return
+	}
+
+	// the snapshot's trees are the index's own, whether or not they were rebuilt
+	trees := idx.IndexTrees()
+	for _, tree := range trees {
+		if store.HasObject(tree.Hash) {
+			continue
+		}
+		if _, err := gitfmt.WriteLooseObject(gitDir, format, gitfmt.TreeObject, tree.Content); err != nil {
+			log.Error().Str("dir", gitDir).Str("path", tree.Path).Err(err).Msg("couldn't write tree object")
+			return
+		}
+	}
+	// the root tree is built last
+	hash, err := writeIndexCommit(gitDir, format, idx, trees[len(trees)-1].Hash, head)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't write index commit")
+		return
+	}
+	staged.Commit = hash
+	report.Index = staged
+	log.Info().Str("dir", gitDir).Str("ref", indexRef).Str("commit", hash).
+		Int("added", len(staged.Added)).Int("modified", len(staged.Modified)).Int("deleted", len(staged.Deleted)).
+		Msg("wrote commit of the index")
+}
+
+// diffIndex compares the index entries with the tree, leaving out what is
+// below trees that weren't recovered.
+func diffIndex(gitDir string, idx *gitfmt.Index, store *gitfmt.Store, tree string, staged *IndexReport) {
+	// directories end in a slash, like those of a sparse index
+	head := make(map[string]gitfmt.TreeEntry)
+	var unreadable []string
+	var walk func(hash, prefix string)
+	walk = func(hash, prefix string) {
+		entries, err := store.ReadTree(hash)
+		if err != nil {
+			log.Warn().Str("dir", gitDir).Str("path", prefix).Str("tree", hash).Msg("couldn't read tree, not comparing the index below it")
+			unreadable = append(unreadable, prefix)
+			return
+		}
+		for _, e := range entries {
+			p := prefix + e.Name
+			if filemode.FileMode(e.Mode) == filemode.Dir {
+				p += "/"
+				walk(e.Hash, p)
+			}
+			head[p] = e
+		}
+	}
+	if tree != "" {
+		walk(tree, "")
+	}
+	isUnreadable := func(p string) bool {
+		for _, dir := range unreadable {
+			if strings.HasPrefix(p, dir) {
+				return true
+			}
+		}
+		return false
+	}
+
+	inIndex := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		// unmerged paths aren't deleted, but aren't part of the snapshot either
+		inIndex[entry.Name] = true
+		if entry.Stage != 0 || entry.IntentToAdd || isUnreadable(entry.Name) {
+			continue
+		}
+		if e, ok := head[entry.Name]; !ok {
+			staged.Added = append(staged.Added, entry.Name)
+		} else if e.Hash != entry.Hash || e.Mode != entry.Mode {
+			staged.Modified = append(staged.Modified, entry.Name)
+		}
+	}
+	for p := range head {
+		if strings.HasSuffix(p, "/") || inIndex[p] || inSparseDir(p, inIndex) {
+			continue
+		}
+		staged.Deleted = append(staged.Deleted, p)
+	}
+	sort.Strings(staged.Added)
+	sort.Strings(staged.Modified)
+	sort.Strings(staged.Deleted)
+}
+
+// inSparseDir reports whether a directory of a sparse index covers p.
+func inSparseDir(p string, inIndex map[string]bool) bool {
+	for i, r := range p {
+		if r == '/' && inIndex[p[:i+1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// writeIndexCommit commits tree on top of parent, dated like the most
+// recently modified index entry, and points refs/goop/index at it.
+func writeIndexCommit(gitDir string, format gitfmt.ObjectFormat, idx *gitfmt.Index, tree, parent string) (string, error) {
+	var when int64
+	for _, entry := range idx.Entries {
+		if t := entry.ModifiedAt.Unix(); t > when {
+			when = t
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", tree)
+	if parent != "" {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	fmt.Fprintf(&buf, "author goop <goop@localhost> %d +0000\n", when)
+	fmt.Fprintf(&buf, "committer goop <goop@localhost> %d +0000\n", when)
+	buf.WriteString("\nIndex snapshot recovered by goop\n")
+
+	hash, err := gitfmt.WriteLooseObject(gitDir, format, gitfmt.CommitObject, buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	refPath := utils.Url(gitDir, indexRef)
+	if err := utils.CreateParentFolders(refPath); err != nil {
+		return "", err
+	}
+	return hash, ioutil.WriteFile(refPath, []byte(hash+"\n"), os.ModePerm)
+}
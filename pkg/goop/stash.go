@@ -0,0 +1,94 @@
+package goop
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/phuslu/log"
+)
+
+// stashRefPrefix is where recovered stashes are exposed, refs/stash itself only
+// points at the newest one
+const stashRefPrefix = "refs/goop/stash/"
+
+// recoverStashes exposes every entry of the stash reflog as refs/goop/stash/N,
+// counting from the newest entry just like stash@{N}. A stash commit's parents
+// are the commit it was made on, the staged changes and, if untracked files
+// were stashed, those.
+func recoverStashes(gitDir string, format gitfmt.ObjectFormat, report *Report) {
+	content, err := ioutil.ReadFile(utils.Url(gitDir, "logs/refs/stash"))
+	if err != nil {
+		return
+	}
+	type entry struct{ hash, message string }
+	var entries []entry
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		var message string
+		if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+			line, message = line[:tab], line[tab+1:]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !format.IsHash(fields[1]) {
+			continue
+		}
+		entries = append(entries, entry{hash: fields[1], message: message})
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't open object store")
+		return
+	}
+	defer store.Close()
+
+	for i := range entries {
+		e := entries[len(entries)-1-i]
+		stash := &StashReport{Ref: fmt.Sprintf("%s%d", stashRefPrefix, i), Commit: e.hash, Message: e.message}
+		report.Stashes = append(report.Stashes, stash)
+
+		typ, content, err := store.ReadObject(e.hash)
+		if err != nil || typ != gitfmt.CommitObject {
+			log.Warn().Str("dir", gitDir).Str("stash", e.hash).Msg("couldn't recover stash commit")
+			stash.Missing = append(stash.Missing, e.hash)
+			continue
+		}
+		commit := gitfmt.ParseCommit(content)
+		for p, parent := range commit.Parents {
+			switch p {
+			case 0:
+				stash.Base = parent
+			case 1:
+				stash.Index = parent
+			case 2:
+				stash.Untracked = parent
+			}
+		}
+		for _, hash := range append([]string{commit.Tree}, commit.Parents...) {
+			if !store.HasObject(hash) {
+				stash.Missing = append(stash.Missing, hash)
+			}
+		}
+
+		refPath := utils.Url(gitDir, stash.Ref)
+		if err := utils.CreateParentFolders(refPath); err != nil {
+			log.Error().Str("file", refPath).Err(err).Msg("couldn't create parent directories")
+			continue
+		}
+		if err := ioutil.WriteFile(refPath, []byte(e.hash+"\n"), os.ModePerm); err != nil {
+			log.Error().Str("file", refPath).Err(err).Msg("couldn't write stash ref")
+			continue
+		}
+		log.Info().Str("dir", gitDir).Str("ref", stash.Ref).Str("subject", e.message).Msg("recovered stash")
+	}
+}
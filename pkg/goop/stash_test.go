@@ -0,0 +1,89 @@
+package goop
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+)
+
+func TestRecoverStashes(t *testing.T) {
+	tests := []struct {
+		name string
+		// revision whose loose object is deleted before recovering
+		remove string
+	}{
+		{"complete", ""},
+		{"missing index commit", "stash@{1}^2"},
+		{"missing stash commit", "stash@{0}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := gitRepo(t, `
+git init -q
+echo a > a.txt
+git add .
+git commit -qm init
+echo b > a.txt
+git stash push -q -m first
+echo c > a.txt
+echo u > u.txt
+git stash push -q -u -m second
+`)
+			gitDir := filepath.Join(dir, ".git")
+			rev := func(r string) string { return gitOutput(t, dir, "rev-parse", r) }
+			branch := gitOutput(t, dir, "symbolic-ref", "--short", "HEAD")
+			want := []*StashReport{
+				{
+					Ref:       stashRefPrefix + "0",
+					Commit:    rev("stash@{0}"),
+					Message:   "On " + branch + ": second",
+					Base:      rev("HEAD"),
+					Index:     rev("stash@{0}^2"),
+					Untracked: rev("stash@{0}^3"),
+				},
+				{
+					Ref:     stashRefPrefix + "1",
+					Commit:  rev("stash@{1}"),
+					Message: "On " + branch + ": first",
+					Base:    rev("HEAD"),
+					Index:   rev("stash@{1}^2"),
+				},
+			}
+			if tt.remove != "" {
+				hash := rev(tt.remove)
+				if err := os.Remove(filepath.Join(gitDir, gitfmt.LooseObjectPath(hash))); err != nil {
+					t.Fatal(err)
+				}
+				for _, s := range want {
+					switch hash {
+					case s.Commit:
+						*s = StashReport{Ref: s.Ref, Commit: s.Commit, Message: s.Message, Missing: []string{hash}}
+					case s.Index:
+						s.Missing = []string{hash}
+					}
+				}
+			}
+
+			report := &Report{}
+			recoverStashes(gitDir, gitfmt.SHA1, report)
+			if !reflect.DeepEqual(report.Stashes, want) {
+				t.Fatalf("got %+v, want %+v", report.Stashes, want)
+			}
+			for _, s := range want {
+				refPath := filepath.Join(gitDir, s.Ref)
+				if len(s.Missing) > 0 && s.Missing[0] == s.Commit {
+					if utils.Exists(refPath) {
+						t.Errorf("%s: written for a missing commit", s.Ref)
+					}
+				} else if got := strings.TrimSpace(readFile(t, refPath)); got != s.Commit {
+					t.Errorf("%s: got %s, want %s", s.Ref, got, s.Commit)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package goop
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/phuslu/log"
+)
+
+// submodules nested deeper than this are most likely a server answering every path
+const maxSubmoduleDepth = 5
+
+// fetchSubmodules dumps the repositories of all submodules of the repository at
+// gitDir into their place in the worktree. Submodules declared in .gitmodules
+// and gitlinks found in the index or HEAD are considered. git keeps absorbed
+// submodule repositories in .git/modules/<name>, older checkouts have a .git
+// directory inside the submodule worktree instead.
+func fetchSubmodules(baseUrl, baseDir, gitUrl, gitDir string, format gitfmt.ObjectFormat, depth int, report *Report) {
+	modules := make(map[string]*SubmoduleReport)
+	if content, err := ioutil.ReadFile(utils.Url(baseDir, ".gitmodules")); err == nil {
+		declared, err := gitfmt.ParseGitmodules(content)
+		if err != nil {
+			log.Error().Str("dir", baseDir).Err(err).Msg("couldn't parse .gitmodules")
+		}
+		for _, m := range declared {
+			modules[m.Path] = &SubmoduleReport{Name: m.Name, Path: m.Path, Url: m.Url}
+		}
+	}
+	for p, hash := range findGitlinks(gitDir, gitUrl, format) {
+		if _, ok := modules[p]; !ok {
+			// git names submodules after their path unless told otherwise
+			modules[p] = &SubmoduleReport{Name: p, Path: p}
+		}
+		modules[p].Commit = hash
+	}
+	if len(modules) == 0 {
+		return
+	}
+	if depth >= maxSubmoduleDepth {
+		log.Warn().Str("dir", gitDir).Msg("submodules are nested too deep, not following them")
+		return
+	}
+
+	var paths []string
+	for p := range modules {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		m := modules[p]
+		report.Submodules = append(report.Submodules, m)
+		if !isSafeWorktreePath(m.Path) || !isSafeWorktreePath(m.Name) {
+			log.Warn().Str("dir", baseDir).Str("submodule", m.Name).Str("path", m.Path).Msg("refusing to dump submodule outside of the repository")
+			continue
+		}
+
+		subUrl := utils.Url(baseUrl, m.Path)
+		subDir := utils.Url(baseDir, m.Path)
+		subGitUrl := utils.Url(gitUrl, "modules/"+m.Name)
+		subGitDir := utils.Url(gitDir, "modules/"+m.Name)
+		if !isGitHead(utils.Url(subGitUrl, "HEAD")) {
+			if !isGitHead(utils.Url(subUrl, ".git/HEAD")) {
+				log.Warn().Str("base", baseUrl).Str("submodule", m.Name).Msg("couldn't find submodule repository")
+				continue
+			}
+			subGitUrl = utils.Url(subUrl, ".git")
+			subGitDir = utils.Url(subDir, ".git")
+		} else if err := writeGitFile(subDir, subGitDir); err != nil {
+			log.Error().Str("dir", subDir).Err(err).Msg("couldn't link submodule worktree to its repository")
+			continue
+		}
+
+		log.Info().Str("base", baseUrl).Str("submodule", m.Name).Str("path", m.Path).Msg("dumping submodule")
+		if err := fetchGit(subUrl, subDir, subGitUrl, subGitDir, depth+1); err != nil {
+			log.Error().Str("base", subUrl).Str("submodule", m.Name).Err(err).Msg("failed to dump submodule")
+			continue
+		}
+		m.Dumped = utils.Exists(utils.Url(subGitDir, "HEAD"))
+	}
+}
+
+// findGitlinks returns the commits submodules are pinned to by the index and
+// the tree of HEAD, keyed by their path.
+func findGitlinks(gitDir, gitUrl string, format gitfmt.ObjectFormat) map[string]string {
+	gitlinks := make(map[string]string)
+	if idx := readIndex(gitDir, gitUrl, format); idx != nil {
+		for _, entry := range idx.Entries {
+			if filemode.FileMode(entry.Mode) == filemode.Submodule {
+				gitlinks[entry.Name] = entry.Hash
+			}
+		}
+	}
+
+	head, err := gitfmt.ResolveRef(gitDir, "HEAD", format)
+	if err != nil {
+		return gitlinks
+	}
+	store, err := gitfmt.OpenStore(gitDir, format)
+	if err != nil {
+		log.Error().Str("dir", gitDir).Err(err).Msg("couldn't open object store")
+		return gitlinks
+	}
+	defer store.Close()
+
+	var walk func(hash, dir string)
+	walk = func(hash, dir string) {
+		entries, err := store.ReadTree(hash)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			switch filemode.FileMode(entry.Mode) {
+			case filemode.Submodule:
+				p := path.Join(dir, entry.Name)
+				if _, ok := gitlinks[p]; !ok {
+					gitlinks[p] = entry.Hash
+				}
+			case filemode.Dir:
+				walk(entry.Hash, path.Join(dir, entry.Name))
+			}
+		}
+	}
+	walk(head, "")
+	return gitlinks
+}
+
+func isGitHead(u string) bool {
+	code, body, err := c.Get(nil, u)
+	if err != nil || code != 200 {
+		return false
+	}
+	body = bytes.TrimSpace(body)
+	return bytes.HasPrefix(body, refPrefix) || gitfmt.SHA1.IsHash(string(body)) || gitfmt.SHA256.IsHash(string(body))
+}
+
+// isSafeWorktreePath rejects submodule names and paths as well as index
+// entries that would escape the directory they are placed in or write into a
+// .git directory, just like git does.
+func isSafeWorktreePath(p string) bool {
+	if p == "" || strings.HasPrefix(p, "/") || strings.ContainsRune(p, '\\') {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." || strings.EqualFold(part, ".git") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeGitFile points the worktree at dir to the git directory at gitDir using
+// a gitdir file, the way git links absorbed submodules.
+func writeGitFile(dir, gitDir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	absGitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absDir, absGitDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(utils.Url(dir, ".git"), []byte(fmt.Sprintf("gitdir: %s\n", filepath.ToSlash(rel))), os.ModePerm)
+}
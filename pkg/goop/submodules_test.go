@@ -0,0 +1,105 @@
+package goop
+
+import (
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sync/atomic"
+	"testing"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+)
+
+func TestFindGitlinks(t *testing.T) {
+	dir := gitRepo(t, `
+git init -q
+git update-index --add --cacheinfo 160000,1111111111111111111111111111111111111111,lib/a
+git update-index --add --cacheinfo 160000,2222222222222222222222222222222222222222,b
+git commit -qm init
+git update-index --cacheinfo 160000,3333333333333333333333333333333333333333,b
+git update-index --add --cacheinfo 160000,4444444444444444444444444444444444444444,c
+`)
+	got := findGitlinks(filepath.Join(dir, ".git"), "", gitfmt.SHA1)
+	// the index takes precedence over HEAD
+	want := map[string]string{
+		"lib/a": "1111111111111111111111111111111111111111",
+		"b":     "3333333333333333333333333333333333333333",
+		"c":     "4444444444444444444444444444444444444444",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIsSafeWorktreePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"vendor/lib", true},
+		{"lib..old", true},
+		{"", false},
+		{"/etc", false},
+		{"../lib", false},
+		{"vendor/../../lib", false},
+		{".git/hooks", false},
+		{"vendor/.GIT/config", false},
+		{"vendor\\lib", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := isSafeWorktreePath(tt.path); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchSubmodulesDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth int
+		// whether the submodule and the one nested in it are dumped
+		dumped, nested bool
+	}{
+		{"below the limit", maxSubmoduleDepth - 1, true, false},
+		{"at the limit", maxSubmoduleDepth, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a repository containing itself as a submodule, which isn't
+			// absorbed, so every level is found below the worktree
+			dir := gitRepo(t, `
+git init -q
+echo hi > a.txt
+printf '[submodule "s"]\n\tpath = s\n\turl = ../s\n' > .gitmodules
+git add .
+git update-index --add --cacheinfo 160000,$(git hash-object a.txt),s
+git commit -qm init
+`)
+			nested := regexp.MustCompile(`^/(s/)+`)
+			var requests int32
+			s := serveDir(t, dir, func(p string) string {
+				atomic.AddInt32(&requests, 1)
+				return nested.ReplaceAllString(p, "/")
+			})
+
+			report := &Report{}
+			gitDir := filepath.Join(dir, ".git")
+			fetchSubmodules(s.URL, dir, s.URL+"/.git", gitDir, gitfmt.SHA1, tt.depth, report)
+			if !tt.dumped && requests > 0 {
+				t.Errorf("got %d requests past the depth limit", requests)
+			}
+			if got := utils.Exists(filepath.Join(dir, "s/.git/HEAD")); got != tt.dumped {
+				t.Errorf("submodule dumped: got %v, want %v", got, tt.dumped)
+			}
+			if got := utils.Exists(filepath.Join(dir, "s/s/.git/HEAD")); got != tt.nested {
+				t.Errorf("nested submodule dumped: got %v, want %v", got, tt.nested)
+			}
+			if tt.dumped && (len(report.Submodules) != 1 || !report.Submodules[0].Dumped) {
+				t.Errorf("got submodules %+v", report.Submodules)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package goop
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+// TagMissLimit is the amount of consecutive missing versions after which goop
+// stops guessing further versions in one direction.
+var TagMissLimit = 3
+
+// upper bound for the amount of versions probed per run, in case a server
+// answers every request
+const maxTagProbes = 5000
+
+var (
+	knownTagRegex = regexp.MustCompile(`(?m)refs/tags/([^\s^]+)|tag '([^']+)'`)
+	versionRegex  = regexp.MustCompile(`^(v|V)?(0|[1-9]\d*)\.(0|[1-9]\d*)(?:\.(0|[1-9]\d*))?$`)
+)
+
+type version struct {
+	prefix              string
+	major, minor, patch int
+	// whether the patch version is part of the tag
+	hasPatch bool
+}
+
+func parseVersion(tag string) (version, bool) {
+	match := versionRegex.FindStringSubmatch(tag)
+	if match == nil {
+		return version{}, false
+	}
+	v := version{prefix: match[1], hasPatch: match[4] != ""}
+	v.major, _ = strconv.Atoi(match[2])
+	v.minor, _ = strconv.Atoi(match[3])
+	if v.hasPatch {
+		v.patch, _ = strconv.Atoi(match[4])
+	}
+	return v, true
+}
+
+func (v version) String() string {
+	if v.hasPatch {
+		return fmt.Sprintf("%s%d.%d.%d", v.prefix, v.major, v.minor, v.patch)
+	}
+	return fmt.Sprintf("%s%d.%d", v.prefix, v.major, v.minor)
+}
+
+// tagWalk steps through versions in one direction from a version that exists.
+type tagWalk struct {
+	from   version
+	step   func(v version, n int) (version, bool)
+	n      int
+	misses int
+}
+
+var tagSteps = []func(v version, n int) (version, bool){
+	// patch increments and decrements
+	func(v version, n int) (version, bool) { v.patch += n; return v, v.hasPatch },
+	func(v version, n int) (version, bool) { v.patch -= n; return v, v.hasPatch && v.patch >= 0 },
+	// minor increments and decrements
+	func(v version, n int) (version, bool) { v.minor += n; v.patch = 0; return v, true },
+	func(v version, n int) (version, bool) { v.minor -= n; v.patch = 0; return v, v.minor >= 0 },
+	// major increments and decrements
+	func(v version, n int) (version, bool) { v.major += n; v.minor, v.patch = 0, 0; return v, true },
+	func(v version, n int) (version, bool) { v.major -= n; v.minor, v.patch = 0, 0; return v, v.major >= 0 },
+}
+
+// tagProber guesses the neighbouring versions of tags that look like version
+// numbers, keeping track of what it already tried across calls.
+type tagProber struct {
+	expanded map[string]bool
+	probed   int
+}
+
+func newTagProber() *tagProber {
+	return &tagProber{expanded: make(map[string]bool)}
+}
+
+// probe expands all version tags known in gitDir that haven't been expanded yet.
+func (p *tagProber) probe(gitDir, gitUrl string) {
+	known := make(map[string]bool)
+	for _, match := range knownTagRegex.FindAllStringSubmatch(seenRefs(gitDir), -1) {
+		known[match[1]+match[2]] = true
+	}
+	exists := func(tag string) bool {
+		return known[tag] || utils.Exists(utils.Url(gitDir, "refs/tags/"+tag))
+	}
+
+	var walks []*tagWalk
+	expand := func(v version) {
+		// the same version is only walked once, whatever its prefix
+		v.prefix = ""
+		if p.expanded[v.String()] {
+			return
+		}
+		p.expanded[v.String()] = true
+		for _, step := range tagSteps {
+			walks = append(walks, &tagWalk{from: v, step: step})
+		}
+	}
+	for tag := range known {
+		if v, ok := parseVersion(tag); ok {
+			expand(v)
+		}
+	}
+	if len(walks) == 0 {
+		return
+	}
+	log.Info().Str("base", gitUrl).Int("count", len(walks)/len(tagSteps)).Msg("guessing tags next to known versions")
+
+	ctx := findRefContext(gitUrl, gitDir)
+	for len(walks) > 0 && p.probed < maxTagProbes {
+		// each walk advances by one version per round
+		var candidates []version
+		jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+		var active []*tagWalk
+		for _, w := range walks {
+			w.n++
+			v, ok := w.step(w.from, w.n)
+			if !ok {
+				continue
+			}
+			active = append(active, w)
+			candidates = append(candidates, v)
+			for _, tag := range versionSpellings(v) {
+				jt.AddJobs("refs/tags/"+tag, "logs/refs/tags/"+tag)
+				p.probed++
+			}
+		}
+		if len(active) == 0 {
+			break
+		}
+		jt.StartAndWait(ctx, true)
+
+		walks = nil
+		for i, w := range active {
+			found := false
+			for _, tag := range versionSpellings(candidates[i]) {
+				if exists(tag) {
+					found = true
+					if v, ok := parseVersion(tag); ok {
+						expand(v)
+					}
+				}
+			}
+			if found {
+				w.misses = 0
+			} else {
+				w.misses++
+			}
+			if w.misses < TagMissLimit {
+				walks = append(walks, w)
+			}
+		}
+	}
+}
+
+// versionSpellings returns v with and without a v prefix, as well as with its
+// own prefix if that's neither.
+func versionSpellings(v version) []string {
+	spellings := []string{v.String()}
+	for _, prefix := range []string{"", "v"} {
+		if prefix != v.prefix {
+			other := v
+			other.prefix = prefix
+			spellings = append(spellings, other.String())
+		}
+	}
+	return spellings
+}
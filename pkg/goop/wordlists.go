@@ -0,0 +1,157 @@
+package goop
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/deletescape/goop/internal/workers"
+)
+
+// Wordlists are the names goop guesses refs and worktree files from, as refs
+// can't be listed without directory listing.
+type Wordlists struct {
+	Branches []string
+	Tags     []string
+	Remotes  []string
+	// Files are relative to the worktree
+	Files []string
+}
+
+var DefaultWordlists = Wordlists{
+	Branches: []string{
+		"master",
+		"main",
+		"dev",
+		"develop",
+		"development",
+		"staging",
+		"stage",
+		"production",
+		"prod",
+		"live",
+		"release",
+		"test",
+		"testing",
+		"qa",
+		"hotfix",
+		"feature",
+		"gh-pages",
+		"deploy",
+		"trunk",
+		"next",
+		"beta",
+		"stable",
+		"wip",
+	},
+	Tags: []string{
+		"alpha",
+		"beta",
+		"stable",
+		"release",
+		"latest",
+		"production",
+		"1.0",
+		"1.0.0",
+		"2.0",
+		"2.0.0",
+		"v1",
+		"v2",
+		"v1.0",
+		"v1.0.0",
+		"v2.0",
+		"v2.0.0",
+	},
+	Remotes: []string{
+		"origin",
+		"upstream",
+		"github",
+		"gitlab",
+		"bitbucket",
+		"heroku",
+		"production",
+		"staging",
+	},
+	Files: []string{
+		".env",
+		".env.local",
+		".env.production",
+		".htaccess",
+		".htpasswd",
+		".editorconfig",
+		".travis.yml",
+		".gitlab-ci.yml",
+	},
+}
+
+var wordlists = DefaultWordlists
+
+// LoadWordlists extends the bundled wordlists with the entries of the given
+// files, one per line. Empty paths are skipped.
+func LoadWordlists(branches, tags, remotes, files string) error {
+	for _, wl := range []struct {
+		file string
+		list *[]string
+	}{
+		{branches, &wordlists.Branches},
+		{tags, &wordlists.Tags},
+		{remotes, &wordlists.Remotes},
+		{files, &wordlists.Files},
+	} {
+		if wl.file == "" {
+			continue
+		}
+		entries, err := readWordlist(wl.file)
+		if err != nil {
+			return err
+		}
+		*wl.list = appendUnique(*wl.list, entries...)
+	}
+	return nil
+}
+
+// readWordlist reads a file with one entry per line, ignoring blank lines and
+// lines starting with #.
+func readWordlist(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Trim(line, "/"))
+	}
+	return entries, scanner.Err()
+}
+
+func appendUnique(list []string, entries ...string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, e := range list {
+		seen[e] = true
+	}
+	for _, e := range entries {
+		if !seen[e] {
+			seen[e] = true
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+func findRefContext(gitUrl, gitDir string) workers.FindRefContext {
+	return workers.FindRefContext{
+		C:        c,
+		BaseUrl:  gitUrl,
+		BaseDir:  gitDir,
+		Branches: wordlists.Branches,
+		Tags:     wordlists.Tags,
+		Remotes:  wordlists.Remotes,
+	}
+}
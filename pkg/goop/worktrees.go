@@ -0,0 +1,191 @@
+package goop
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/deletescape/goop/internal/gitfmt"
+	"github.com/deletescape/goop/internal/utils"
+	"github.com/deletescape/goop/internal/workers"
+	"github.com/deletescape/jobtracker"
+	"github.com/phuslu/log"
+)
+
+var gitFilePrefix = []byte("gitdir:")
+
+// worktreeFiles are the files git keeps per worktree, relative to its git directory
+var worktreeFiles = []string{
+	"HEAD",
+	"ORIG_HEAD",
+	"FETCH_HEAD",
+	"logs/HEAD",
+	"index",
+	"config.worktree",
+}
+
+// resolveGitFile checks whether .git at baseUrl is a gitdir file rather than a
+// directory and returns the url of the git directory it points at.
+func resolveGitFile(baseUrl string) string {
+	body := bytes.TrimSpace(fetchText(utils.Url(baseUrl, ".git")))
+	if !bytes.HasPrefix(body, gitFilePrefix) {
+		return ""
+	}
+	target := strings.TrimSpace(string(bytes.TrimPrefix(body, gitFilePrefix)))
+	log.Info().Str("base", baseUrl).Str("gitdir", target).Msg(".git is a gitdir file")
+	return resolveGitPath(baseUrl, target)
+}
+
+// resolveGitPath turns a path to a git directory as written by git into a url.
+// Relative paths are relative to dirUrl, absolute paths are guessed.
+func resolveGitPath(dirUrl, p string) string {
+	base, err := url.Parse(dirUrl + "/")
+	if err != nil {
+		return ""
+	}
+	if strings.HasPrefix(p, "/") {
+		found := guessAbsoluteUrls(base, p, func(candidate string) bool {
+			return isGitHead(utils.Url(candidate, "HEAD"))
+		})
+		if len(found) == 0 {
+			log.Warn().Str("base", dirUrl).Str("gitdir", p).Msg("couldn't find git directory on the server")
+			return ""
+		}
+		return found[0]
+	}
+	ref, err := url.Parse(p)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(base.ResolveReference(ref).String(), "/")
+}
+
+// fetchLinkedWorktree fetches the state of the linked worktree with its git
+// directory at wtUrl into gitDir and returns the url of the common git directory
+// holding objects and refs. If wtUrl isn't a linked worktree it is returned as is.
+func fetchLinkedWorktree(wtUrl, gitDir string) string {
+	commonDir := strings.TrimSpace(string(fetchText(utils.Url(wtUrl, "commondir"))))
+	if commonDir == "" {
+		if path.Base(path.Dir(wtUrl)) != "worktrees" {
+			return wtUrl
+		}
+		commonDir = "../.."
+	}
+	commonUrl := resolveGitPath(wtUrl, commonDir)
+	if commonUrl == "" {
+		return wtUrl
+	}
+	log.Info().Str("worktree", wtUrl).Str("common", commonUrl).Msg("following linked worktree to its common git directory")
+
+	// the worktree's own state takes precedence over the one of the main worktree,
+	// and is kept in .git/worktrees/<name> as well just like on the server
+	name := path.Base(wtUrl)
+	jt := jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(worktreeFiles...)
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: wtUrl, BaseDir: gitDir}, false)
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	jt.AddJobs(worktreeFiles...)
+	jt.AddJobs("gitdir", "commondir")
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: wtUrl, BaseDir: utils.Url(gitDir, "worktrees/"+name)}, false)
+	return commonUrl
+}
+
+// fetchWorktrees enumerates the linked worktrees of the repository, whose names
+// are guessed from the branches found so far, and adds the objects referenced
+// by their state to objs.
+func fetchWorktrees(gitDir, gitUrl string, format gitfmt.ObjectFormat, report *Report, objs map[string]bool) {
+	candidates := make(map[string]bool)
+	if dirs, err := ioutil.ReadDir(utils.Url(gitDir, "worktrees")); err == nil {
+		for _, dir := range dirs {
+			if dir.IsDir() {
+				candidates[dir.Name()] = true
+			}
+		}
+	}
+	for _, branch := range localBranches(gitDir) {
+		// git names worktrees after the last component of their path, which
+		// usually matches the branch checked out in them
+		candidates[path.Base(branch)] = true
+	}
+
+	var names []string
+	for name := range candidates {
+		if name != "" && name != "." && name != ".." {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	jt := jobtracker.NewJobTracker(workers.FindRefWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, name := range names {
+		jt.AddJobs("worktrees/"+name+"/HEAD", "worktrees/"+name+"/ORIG_HEAD", "worktrees/"+name+"/logs/HEAD")
+	}
+	jt.StartAndWait(findRefContext(gitUrl, gitDir), true)
+
+	jt = jobtracker.NewJobTracker(workers.DownloadWorker, maxConcurrency, jobtracker.DefaultNapper)
+	for _, name := range names {
+		if utils.Exists(utils.Url(gitDir, "worktrees/"+name+"/HEAD")) {
+			jt.AddJobs("worktrees/"+name+"/index", "worktrees/"+name+"/gitdir", "worktrees/"+name+"/commondir", "worktrees/"+name+"/FETCH_HEAD")
+		}
+	}
+	jt.StartAndWait(workers.DownloadContext{C: c, BaseUrl: gitUrl, BaseDir: gitDir}, false)
+
+	for _, name := range names {
+		wtDir := utils.Url(gitDir, "worktrees/"+name)
+		head, err := ioutil.ReadFile(utils.Url(wtDir, "HEAD"))
+		if err != nil {
+			continue
+		}
+		log.Info().Str("dir", gitDir).Str("worktree", name).Msg("found linked worktree")
+		wt := &WorktreeReport{Name: name, Head: strings.TrimSpace(string(head))}
+		if p, err := ioutil.ReadFile(utils.Url(wtDir, "gitdir")); err == nil {
+			wt.Path = strings.TrimSuffix(strings.TrimSpace(string(p)), "/.git")
+		}
+		report.Worktrees = append(report.Worktrees, wt)
+
+		for _, f := range []string{"HEAD", "ORIG_HEAD", "FETCH_HEAD", "logs/HEAD"} {
+			content, err := ioutil.ReadFile(utils.Url(wtDir, f))
+			if err != nil {
+				continue
+			}
+			for _, obj := range objRegex.FindAll(content, -1) {
+				objs[strings.TrimSpace(string(obj))] = true
+			}
+		}
+		if idx := readIndexFile(gitDir, gitUrl, "worktrees/"+name+"/index", format); idx != nil {
+			for _, hash := range indexObjects(gitDir, idx) {
+				objs[hash] = true
+			}
+		}
+	}
+}
+
+// localBranches lists the branch names found in refs/heads and packed-refs.
+func localBranches(gitDir string) []string {
+	var branches []string
+	headsDir := utils.Url(gitDir, "refs/heads")
+	filepath.Walk(headsDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			if rel, err := filepath.Rel(headsDir, p); err == nil {
+				branches = append(branches, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	if content, err := ioutil.ReadFile(utils.Url(gitDir, "packed-refs")); err == nil {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			parts := strings.SplitN(scanner.Text(), " ", 2)
+			if len(parts) == 2 && strings.HasPrefix(parts[1], "refs/heads/") {
+				branches = append(branches, strings.TrimPrefix(parts[1], "refs/heads/"))
+			}
+		}
+	}
+	return branches
+}